@@ -0,0 +1,245 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Kind is the type of value of a Node.
+type Kind uint8
+
+const (
+	Invalid Kind = iota
+	Null
+	Bool
+	Number
+	String
+	Array
+	Object
+)
+
+var kindNames = [...]string{
+	Invalid: "invalid",
+	Null:    "null",
+	Bool:    "bool",
+	Number:  "number",
+	String:  "string",
+	Array:   "array",
+	Object:  "object",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Source is the input the nodes are parsed from.
+type Source struct {
+	// Name is the file name reported in positions.
+	Name string
+	// Data is the content of the input.
+	Data []byte
+
+	// Offset, Line and Column are the position of the first byte of Data within the file,
+	// when Data is a part of it such as a document of a stream, Line is 1 if zero.
+	Offset int
+	Line   int
+	Column int
+
+	once  sync.Once
+	lines []int
+}
+
+// Position is a location in a Source.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) String() string {
+	s := p.Filename
+	if p.Line > 0 {
+		if s != "" {
+			s += ":"
+		}
+		s += strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+	}
+	return s
+}
+
+// Position returns the position of the offset within Data.
+func (s *Source) Position(offset int) Position {
+	if s == nil {
+		return Position{}
+	}
+	s.once.Do(func() {
+		for i, c := range s.Data {
+			if c == '\n' {
+				s.lines = append(s.lines, i)
+			}
+		}
+	})
+	line := sort.SearchInts(s.lines, offset)
+	start := 0
+	if line != 0 {
+		start = s.lines[line-1] + 1
+	}
+	p := Position{
+		Filename: s.Name,
+		Offset:   s.Offset + offset,
+		Line:     line + 1,
+		Column:   offset - start + 1,
+	}
+	if s.Line > 0 {
+		p.Line += s.Line - 1
+	}
+	if line == 0 && s.Column > 0 {
+		p.Column += s.Column - 1
+	}
+	return p
+}
+
+// Node is a value of the tree parsed from the input.
+type Node struct {
+	Kind Kind
+
+	// Source and Offset locate the node in the input.
+	Source *Source
+	Offset int
+
+	// Raw is the JSON text of the node as found in the input, it is nil if the input is not JSON.
+	Raw []byte
+
+	// Value is the decoded String, or the literal text of a Number or Bool.
+	Value string
+
+	// Interpolated is set on a String expanded from a single ${...} expression,
+	// it may be decoded as the number or bool it holds.
+	Interpolated bool
+
+	// Members of an Object in document order.
+	Members []Member
+
+	// Elems of an Array.
+	Elems []*Node
+}
+
+// Member is a key and value pair of an Object.
+type Member struct {
+	Key       string
+	KeyOffset int
+	Value     *Node
+}
+
+// Pos returns the position of the node.
+func (n *Node) Pos() Position {
+	return n.Source.Position(n.Offset)
+}
+
+// KeyPos returns the position of the key of the member.
+func (m *Member) KeyPos() Position {
+	return m.Value.Source.Position(m.KeyOffset)
+}
+
+// Lookup returns the last member with the key, as the last one wins in JSON.
+func (n *Node) Lookup(key string) (*Node, bool) {
+	for i := len(n.Members) - 1; i >= 0; i-- {
+		if n.Members[i].Key == key {
+			return n.Members[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Bytes returns the JSON encoding of the node.
+func (n *Node) Bytes() []byte {
+	if n.Raw != nil {
+		return n.Raw
+	}
+	buf := bytes.Buffer{}
+	n.encode(&buf)
+	return buf.Bytes()
+}
+
+func (n *Node) encode(buf *bytes.Buffer) {
+	if n.Raw != nil {
+		buf.Write(n.Raw)
+		return
+	}
+	switch n.Kind {
+	case Null, Invalid:
+		buf.WriteString("null")
+	case Bool, Number:
+		buf.WriteString(n.Value)
+	case String:
+		encodeString(buf, n.Value)
+	case Array:
+		buf.WriteByte('[')
+		for i, elem := range n.Elems {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			elem.encode(buf)
+		}
+		buf.WriteByte(']')
+	case Object:
+		buf.WriteByte('{')
+		for i, m := range n.Members {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			encodeString(buf, m.Key)
+			buf.WriteByte(':')
+			m.Value.encode(buf)
+		}
+		buf.WriteByte('}')
+	}
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
+
+// Interface returns the value of the node as encoding/json decodes it into an interface{}.
+func (n *Node) Interface() interface{} {
+	switch n.Kind {
+	case Bool:
+		return n.Value == "true"
+	case Number:
+		f, _ := strconv.ParseFloat(n.Value, 64)
+		return f
+	case String:
+		return n.Value
+	case Array:
+		s := make([]interface{}, 0, len(n.Elems))
+		for _, elem := range n.Elems {
+			s = append(s, elem.Interface())
+		}
+		return s
+	case Object:
+		m := make(map[string]interface{}, len(n.Members))
+		for _, member := range n.Members {
+			m[member.Key] = member.Value.Interface()
+		}
+		return m
+	}
+	return nil
+}
+
+// SyntaxError is an error of the syntax of the input, the message does not include the position.
+type SyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Msg
+}
@@ -0,0 +1,362 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxDepth is the limit of nesting, the same as encoding/json.
+const maxDepth = 10000
+
+// Parse parses the JSON document in the source into a tree of nodes in a single pass.
+func Parse(src *Source) (*Node, error) {
+	p := parser{src: src, data: src.Data}
+	p.skipSpace()
+	n, err := p.value(0)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.off != len(p.data) {
+		return nil, p.errorf("invalid character %s after top-level value", quoteChar(p.data[p.off]))
+	}
+	return n, nil
+}
+
+// ParseBytes parses the named JSON document.
+func ParseBytes(name string, data []byte) (*Node, error) {
+	return Parse(&Source{Name: name, Data: data})
+}
+
+type parser struct {
+	src  *Source
+	data []byte
+	off  int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Pos: p.src.Position(p.off),
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *parser) unexpected(context string) error {
+	if p.off >= len(p.data) {
+		return p.errorf("unexpected end of JSON input")
+	}
+	return p.errorf("invalid character %s %s", quoteChar(p.data[p.off]), context)
+}
+
+func (p *parser) skipSpace() {
+	for p.off < len(p.data) {
+		switch p.data[p.off] {
+		case ' ', '\t', '\r', '\n':
+			p.off++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) value(depth int) (*Node, error) {
+	if p.off >= len(p.data) {
+		return nil, p.unexpected("")
+	}
+	if depth > maxDepth {
+		return nil, p.errorf("exceeded max depth")
+	}
+	start := p.off
+	n := &Node{Source: p.src, Offset: start}
+	switch c := p.data[p.off]; {
+	case c == '{':
+		err := p.object(n, depth)
+		if err != nil {
+			return nil, err
+		}
+	case c == '[':
+		err := p.array(n, depth)
+		if err != nil {
+			return nil, err
+		}
+	case c == '"':
+		s, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		n.Kind = String
+		n.Value = s
+	case c == '-' || c >= '0' && c <= '9':
+		err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		n.Kind = Number
+		n.Value = string(p.data[start:p.off])
+	case c == 't':
+		err := p.literal("true")
+		if err != nil {
+			return nil, err
+		}
+		n.Kind = Bool
+		n.Value = "true"
+	case c == 'f':
+		err := p.literal("false")
+		if err != nil {
+			return nil, err
+		}
+		n.Kind = Bool
+		n.Value = "false"
+	case c == 'n':
+		err := p.literal("null")
+		if err != nil {
+			return nil, err
+		}
+		n.Kind = Null
+	default:
+		return nil, p.unexpected("looking for beginning of value")
+	}
+	n.Raw = p.data[start:p.off:p.off]
+	return n, nil
+}
+
+func (p *parser) object(n *Node, depth int) error {
+	n.Kind = Object
+	n.Members = []Member{}
+	p.off++
+	p.skipSpace()
+	if p.off < len(p.data) && p.data[p.off] == '}' {
+		p.off++
+		return nil
+	}
+	for {
+		if p.off >= len(p.data) || p.data[p.off] != '"' {
+			return p.unexpected("looking for beginning of object key string")
+		}
+		keyOffset := p.off
+		key, err := p.string()
+		if err != nil {
+			return err
+		}
+		p.skipSpace()
+		if p.off >= len(p.data) || p.data[p.off] != ':' {
+			return p.unexpected("after object key")
+		}
+		p.off++
+		p.skipSpace()
+		value, err := p.value(depth + 1)
+		if err != nil {
+			return err
+		}
+		n.Members = append(n.Members, Member{Key: key, KeyOffset: keyOffset, Value: value})
+		p.skipSpace()
+		if p.off >= len(p.data) {
+			return p.unexpected("")
+		}
+		switch p.data[p.off] {
+		case ',':
+			p.off++
+			p.skipSpace()
+		case '}':
+			p.off++
+			return nil
+		default:
+			return p.unexpected("after object key:value pair")
+		}
+	}
+}
+
+func (p *parser) array(n *Node, depth int) error {
+	n.Kind = Array
+	n.Elems = []*Node{}
+	p.off++
+	p.skipSpace()
+	if p.off < len(p.data) && p.data[p.off] == ']' {
+		p.off++
+		return nil
+	}
+	for {
+		elem, err := p.value(depth + 1)
+		if err != nil {
+			return err
+		}
+		n.Elems = append(n.Elems, elem)
+		p.skipSpace()
+		if p.off >= len(p.data) {
+			return p.unexpected("")
+		}
+		switch p.data[p.off] {
+		case ',':
+			p.off++
+			p.skipSpace()
+		case ']':
+			p.off++
+			return nil
+		default:
+			return p.unexpected("after array element")
+		}
+	}
+}
+
+func (p *parser) literal(lit string) error {
+	for i := 0; i != len(lit); i++ {
+		if p.off >= len(p.data) || p.data[p.off] != lit[i] {
+			return p.unexpected("in literal " + lit + " (expecting " + quoteChar(lit[i]) + ")")
+		}
+		p.off++
+	}
+	return nil
+}
+
+func (p *parser) number() error {
+	if p.data[p.off] == '-' {
+		p.off++
+	}
+	switch {
+	case p.off < len(p.data) && p.data[p.off] == '0':
+		p.off++
+	case p.off < len(p.data) && p.data[p.off] >= '1' && p.data[p.off] <= '9':
+		p.digits()
+	default:
+		return p.unexpected("in numeric literal")
+	}
+	if p.off < len(p.data) && p.data[p.off] == '.' {
+		p.off++
+		if !p.digits() {
+			return p.unexpected("after decimal point in numeric literal")
+		}
+	}
+	if p.off < len(p.data) && (p.data[p.off] == 'e' || p.data[p.off] == 'E') {
+		p.off++
+		if p.off < len(p.data) && (p.data[p.off] == '+' || p.data[p.off] == '-') {
+			p.off++
+		}
+		if !p.digits() {
+			return p.unexpected("in exponent of numeric literal")
+		}
+	}
+	return nil
+}
+
+func (p *parser) digits() bool {
+	start := p.off
+	for p.off < len(p.data) && p.data[p.off] >= '0' && p.data[p.off] <= '9' {
+		p.off++
+	}
+	return p.off != start
+}
+
+// string parses the quoted string at the offset, invalid UTF-8 is replaced with utf8.RuneError as encoding/json does.
+func (p *parser) string() (string, error) {
+	p.off++
+	start := p.off
+	// Fast path for strings without escapes.
+	for p.off < len(p.data) {
+		c := p.data[p.off]
+		if c == '"' {
+			s := string(p.data[start:p.off])
+			p.off++
+			if utf8.ValidString(s) {
+				return s, nil
+			}
+			p.off = start
+			break
+		}
+		if c == '\\' || c < ' ' || c >= utf8.RuneSelf {
+			break
+		}
+		p.off++
+	}
+
+	buf := make([]byte, 0, p.off-start+8)
+	buf = append(buf, p.data[start:p.off]...)
+	for p.off < len(p.data) {
+		c := p.data[p.off]
+		switch {
+		case c == '"':
+			p.off++
+			return string(buf), nil
+		case c < ' ':
+			return "", p.unexpected("in string literal")
+		case c == '\\':
+			p.off++
+			if p.off >= len(p.data) {
+				return "", p.unexpected("")
+			}
+			switch e := p.data[p.off]; e {
+			case '"', '\\', '/':
+				buf = append(buf, e)
+			case 'b':
+				buf = append(buf, '\b')
+			case 'f':
+				buf = append(buf, '\f')
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'u':
+				r, ok := p.hex4(p.off + 1)
+				if !ok {
+					return "", p.unexpected("in \\u hexadecimal character escape")
+				}
+				p.off += 4
+				if utf16.IsSurrogate(r) {
+					r2, ok := rune(-1), false
+					if p.off+2 < len(p.data) && p.data[p.off+1] == '\\' && p.data[p.off+2] == 'u' {
+						r2, ok = p.hex4(p.off + 3)
+					}
+					if dec := utf16.DecodeRune(r, r2); ok && dec != utf8.RuneError {
+						r = dec
+						p.off += 6
+					} else {
+						r = utf8.RuneError
+					}
+				}
+				buf = appendRune(buf, r)
+			default:
+				return "", p.unexpected("in string escape code")
+			}
+			p.off++
+		case c < utf8.RuneSelf:
+			buf = append(buf, c)
+			p.off++
+		default:
+			r, size := utf8.DecodeRune(p.data[p.off:])
+			p.off += size
+			buf = appendRune(buf, r)
+		}
+	}
+	return "", p.unexpected("")
+}
+
+func (p *parser) hex4(i int) (rune, bool) {
+	if i+4 > len(p.data) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(p.data[i:i+4]), 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return rune(v), true
+}
+
+func appendRune(buf []byte, r rune) []byte {
+	var tmp [utf8.UTFMax]byte
+	n := utf8.EncodeRune(tmp[:], r)
+	return append(buf, tmp[:n]...)
+}
+
+func quoteChar(c byte) string {
+	if c == '\'' {
+		return `'\''`
+	}
+	if c == '"' {
+		return `'"'`
+	}
+	s := strconv.Quote(string(c))
+	return "'" + s[1:len(s)-1] + "'"
+}
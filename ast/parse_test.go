@@ -0,0 +1,97 @@
+package ast
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []string{
+		`null`,
+		`true`,
+		` false `,
+		`0`,
+		`-1.5e+10`,
+		`"a\"b\\c\/d\b\f\n\r\té😀"`,
+		`"\ud83d"`,
+		"\"\xff\"",
+		`"héllo"`,
+		`[]`,
+		`{}`,
+		`[1,"2",[3],{"4":5}]`,
+		`{"a":{"b":[null,true]},"a":1}`,
+		`01`,
+		`1.`,
+		`-`,
+		`1e`,
+		`[1,]`,
+		`{"a":1,}`,
+		`{"a" 1}`,
+		`{a:1}`,
+		`[1 2]`,
+		`"a`,
+		`"\x"`,
+		"\"\t\"",
+		`tru`,
+		`nul`,
+		`1 2`,
+		``,
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			var want interface{}
+			wantErr := json.Unmarshal([]byte(tt), &want)
+
+			n, err := ParseBytes("", []byte(tt))
+			if (err != nil) != (wantErr != nil) {
+				t.Fatalf("Parse() error = %v, encoding/json error = %v", err, wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := n.Interface(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Parse() got = %#v, want %#v", got, want)
+			}
+			var again interface{}
+			err = json.Unmarshal(n.Bytes(), &again)
+			if err != nil || !reflect.DeepEqual(again, want) {
+				t.Errorf("Bytes() got = %s, %v", n.Bytes(), err)
+			}
+		})
+	}
+}
+
+func TestPosition(t *testing.T) {
+	data := "{\n  \"a\": [1,\n    2]\n}"
+	n, err := ParseBytes("test.json", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elem := n.Members[0].Value.Elems[1]
+	want := Position{Filename: "test.json", Offset: 17, Line: 3, Column: 5}
+	if got := elem.Pos(); got != want {
+		t.Errorf("Pos() got = %v, want %v", got, want)
+	}
+	if got := n.Members[0].KeyPos(); got.Line != 2 || got.Column != 3 {
+		t.Errorf("KeyPos() got = %v, want 2:3", got)
+	}
+
+	src := &Source{Name: "stream", Data: []byte(data), Offset: 10, Line: 4, Column: 7}
+	n, err = Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Pos(); got.Line != 4 || got.Column != 7 || got.Offset != 10 {
+		t.Errorf("Pos() got = %v, want stream:4:7", got)
+	}
+	if got := n.Members[0].Value.Elems[1].Pos(); got.Line != 6 || got.Column != 5 {
+		t.Errorf("Pos() got = %v, want stream:6:5", got)
+	}
+
+	_, err = ParseBytes("bad.json", []byte("[1,\n  x]"))
+	se, ok := err.(*SyntaxError)
+	if !ok || se.Pos.Line != 2 || se.Pos.Column != 3 {
+		t.Errorf("Parse() error = %#v, want at 2:3", err)
+	}
+}
@@ -0,0 +1,780 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ParseTOML parses the TOML document in the source into a tree of nodes,
+// the tables are objects and the arrays of tables are arrays of objects.
+// The datetimes are strings in the RFC 3339 form with a "T" between the date and the time,
+// inf and nan have no JSON form and are strings too.
+// Inline tables may span lines and end with a comma as in TOML 1.1.
+func ParseTOML(src *Source) (*Node, error) {
+	p := &tomlParser{
+		src:    src,
+		data:   src.Data,
+		tables: map[*Node]*tomlTable{},
+		arrays: map[*Node]bool{},
+	}
+	if bytes.HasPrefix(p.data, []byte("\xef\xbb\xbf")) {
+		p.off = 3
+	}
+	root := p.newTable(p.off, tableHeader)
+	err := p.parse(root)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+const (
+	// tableImplicit is created by the header of a table in it.
+	tableImplicit uint8 = iota
+	// tableHeader is defined by its header.
+	tableHeader
+	// tableDotted is created by a dotted key.
+	tableDotted
+	// tableInline is an inline table or in one, it cannot be extended.
+	tableInline
+)
+
+type tomlTable struct {
+	state uint8
+	index map[string]int
+}
+
+type tomlKey struct {
+	name string
+	off  int
+}
+
+type tomlParser struct {
+	src  *Source
+	data []byte
+	off  int
+	// tables is the state of the tables.
+	tables map[*Node]*tomlTable
+	// arrays is the arrays of tables, other arrays cannot be extended.
+	arrays map[*Node]bool
+}
+
+func (p *tomlParser) errorAt(off int, format string, args ...interface{}) error {
+	return &SyntaxError{
+		Pos: p.src.Position(off),
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *tomlParser) unexpected(context string) error {
+	if p.off >= len(p.data) {
+		return p.errorAt(p.off, "unexpected end of TOML input %s", context)
+	}
+	return p.errorAt(p.off, "unexpected character %s %s", quoteChar(p.data[p.off]), context)
+}
+
+func (p *tomlParser) at(i int) byte {
+	if i < len(p.data) {
+		return p.data[i]
+	}
+	return 0
+}
+
+func (p *tomlParser) parse(root *Node) error {
+	current := root
+	for {
+		p.skipSpace()
+		if p.off >= len(p.data) {
+			return nil
+		}
+		var err error
+		switch c := p.data[p.off]; {
+		case c == '\n' || c == '#' || c == '\r':
+		case c == '[':
+			current, err = p.header(root)
+		default:
+			err = p.keyValue(current, 0)
+		}
+		if err != nil {
+			return err
+		}
+		err = p.endLine()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// header parses the header of a table or of an array of tables and returns the table.
+func (p *tomlParser) header(root *Node) (*Node, error) {
+	start := p.off
+	array := p.at(p.off+1) == '['
+	if array {
+		p.off += 2
+	} else {
+		p.off++
+	}
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.at(p.off) != ']' || array && p.at(p.off+1) != ']' {
+		return nil, p.unexpected("at the end of a table header")
+	}
+	p.off++
+	if array {
+		p.off++
+	}
+
+	t := root
+	for _, k := range keys[:len(keys)-1] {
+		t, err = p.descend(t, k)
+		if err != nil {
+			return nil, err
+		}
+	}
+	last := keys[len(keys)-1]
+	v := p.member(t, last.name)
+	if array {
+		if v == nil {
+			v = &Node{Kind: Array, Source: p.src, Offset: start, Elems: []*Node{}}
+			p.arrays[v] = true
+			p.add(t, last, v)
+		} else if !p.arrays[v] {
+			return nil, p.errorAt(last.off, "key %q is already defined", last.name)
+		}
+		elem := p.newTable(start, tableHeader)
+		v.Elems = append(v.Elems, elem)
+		return elem, nil
+	}
+	if v == nil {
+		v = p.newTable(start, tableHeader)
+		p.add(t, last, v)
+		return v, nil
+	}
+	if tt, ok := p.tables[v]; ok && tt.state == tableImplicit {
+		tt.state = tableHeader
+		v.Offset = start
+		return v, nil
+	}
+	return nil, p.errorAt(last.off, "table %q is already defined", last.name)
+}
+
+// descend returns the table of the key in a header, the last one of an array of tables.
+func (p *tomlParser) descend(t *Node, k tomlKey) (*Node, error) {
+	v := p.member(t, k.name)
+	if v == nil {
+		v = p.newTable(k.off, tableImplicit)
+		p.add(t, k, v)
+		return v, nil
+	}
+	if p.arrays[v] {
+		return v.Elems[len(v.Elems)-1], nil
+	}
+	if tt, ok := p.tables[v]; ok && tt.state != tableInline {
+		return v, nil
+	}
+	return nil, p.errorAt(k.off, "key %q is already defined", k.name)
+}
+
+// keyValue parses a key and its value into the table, the tables of a dotted key are created.
+func (p *tomlParser) keyValue(t *Node, depth int) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.at(p.off) != '=' {
+		return p.unexpected("after a key, expected '='")
+	}
+	p.off++
+	p.skipSpace()
+	value, err := p.value(depth)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys[:len(keys)-1] {
+		v := p.member(t, k.name)
+		if v == nil {
+			v = p.newTable(k.off, tableDotted)
+			p.add(t, k, v)
+		} else if tt, ok := p.tables[v]; !ok || tt.state != tableDotted {
+			return p.errorAt(k.off, "key %q is already defined", k.name)
+		}
+		t = v
+	}
+	last := keys[len(keys)-1]
+	if p.member(t, last.name) != nil {
+		return p.errorAt(last.off, "key %q is already defined", last.name)
+	}
+	p.add(t, last, value)
+	return nil
+}
+
+// key parses a dotted key of bare and quoted parts.
+func (p *tomlParser) key() ([]tomlKey, error) {
+	var keys []tomlKey
+	for {
+		p.skipSpace()
+		off := p.off
+		var name string
+		var err error
+		switch p.at(off) {
+		case '"':
+			if bytes.HasPrefix(p.data[off:], []byte(`"""`)) {
+				return nil, p.errorAt(off, "multi-line strings cannot be keys")
+			}
+			name, err = p.basicString()
+		case '\'':
+			if bytes.HasPrefix(p.data[off:], []byte(`'''`)) {
+				return nil, p.errorAt(off, "multi-line strings cannot be keys")
+			}
+			name, err = p.literalString()
+		default:
+			for p.off < len(p.data) && isBareKey(p.data[p.off]) {
+				p.off++
+			}
+			if p.off == off {
+				return nil, p.unexpected("looking for a key")
+			}
+			name = string(p.data[off:p.off])
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tomlKey{name: name, off: off})
+		p.skipSpace()
+		if p.at(p.off) != '.' {
+			return keys, nil
+		}
+		p.off++
+	}
+}
+
+func (p *tomlParser) value(depth int) (*Node, error) {
+	if depth > maxDepth {
+		return nil, p.errorAt(p.off, "exceeded max depth")
+	}
+	off := p.off
+	n := &Node{Source: p.src, Offset: off}
+	var err error
+	switch c := p.at(off); {
+	case c == '"':
+		n.Kind = String
+		if bytes.HasPrefix(p.data[off:], []byte(`"""`)) {
+			n.Value, err = p.multiLineString('"')
+		} else {
+			n.Value, err = p.basicString()
+		}
+	case c == '\'':
+		n.Kind = String
+		if bytes.HasPrefix(p.data[off:], []byte(`'''`)) {
+			n.Value, err = p.multiLineString('\'')
+		} else {
+			n.Value, err = p.literalString()
+		}
+	case c == '[':
+		err = p.array(n, depth)
+	case c == '{':
+		return p.inlineTable(depth)
+	case p.keyword("true"):
+		n.Kind = Bool
+		n.Value = "true"
+	case p.keyword("false"):
+		n.Kind = Bool
+		n.Value = "false"
+	default:
+		err = p.scalar(n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// keyword reports whether the word is at the offset, skipping it.
+func (p *tomlParser) keyword(word string) bool {
+	if !bytes.HasPrefix(p.data[p.off:], []byte(word)) || isBareKey(p.at(p.off+len(word))) {
+		return false
+	}
+	p.off += len(word)
+	return true
+}
+
+func (p *tomlParser) array(n *Node, depth int) error {
+	n.Kind = Array
+	n.Elems = []*Node{}
+	p.off++
+	for {
+		err := p.skipBlank()
+		if err != nil {
+			return err
+		}
+		if p.at(p.off) == ']' {
+			p.off++
+			return nil
+		}
+		elem, err := p.value(depth + 1)
+		if err != nil {
+			return err
+		}
+		n.Elems = append(n.Elems, elem)
+		err = p.skipBlank()
+		if err != nil {
+			return err
+		}
+		switch p.at(p.off) {
+		case ',':
+			p.off++
+		case ']':
+			p.off++
+			return nil
+		default:
+			return p.unexpected("after an array element")
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable(depth int) (*Node, error) {
+	n := p.newTable(p.off, tableInline)
+	p.off++
+	for {
+		err := p.skipBlank()
+		if err != nil {
+			return nil, err
+		}
+		if p.at(p.off) == '}' {
+			p.off++
+			break
+		}
+		err = p.keyValue(n, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		err = p.skipBlank()
+		if err != nil {
+			return nil, err
+		}
+		if p.at(p.off) == ',' {
+			p.off++
+			continue
+		}
+		if p.at(p.off) != '}' {
+			return nil, p.unexpected("after an inline table entry")
+		}
+	}
+	p.freeze(n)
+	return n, nil
+}
+
+// freeze makes the tables of the inline table unextendable.
+func (p *tomlParser) freeze(n *Node) {
+	if tt, ok := p.tables[n]; ok {
+		tt.state = tableInline
+	}
+	for _, m := range n.Members {
+		p.freeze(m.Value)
+	}
+}
+
+// scalar parses an integer, a float or a datetime.
+func (p *tomlParser) scalar(n *Node) error {
+	start := p.off
+	for p.off < len(p.data) && (isBareKey(p.data[p.off]) || strings.IndexByte(".+:", p.data[p.off]) >= 0) {
+		p.off++
+	}
+	// The space between the date and the time of a datetime.
+	if p.off-start == 10 && p.at(start+4) == '-' && p.at(p.off) == ' ' &&
+		isDigit(p.at(p.off+1)) && isDigit(p.at(p.off+2)) && p.at(p.off+3) == ':' {
+		p.off++
+		for p.off < len(p.data) && (isBareKey(p.data[p.off]) || strings.IndexByte(".+:", p.data[p.off]) >= 0) {
+			p.off++
+		}
+	}
+	tok := string(p.data[start:p.off])
+	if tok == "" {
+		return p.unexpected("looking for a value")
+	}
+	switch tok {
+	case "inf", "+inf", "-inf", "nan", "+nan", "-nan":
+		n.Kind = String
+		n.Value = tok
+		return nil
+	}
+	if s, ok := tomlDatetime(tok); ok {
+		n.Kind = String
+		n.Value = s
+		return nil
+	}
+	if s, ok := tomlInteger(tok); ok {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			// TOML integers are 64 bits.
+			return p.errorAt(start, "integer %s is out of the range of int64", tok)
+		}
+		n.Kind = Number
+		n.Value = s
+		return nil
+	}
+	if s, ok := tomlFloat(tok); ok {
+		n.Kind = Number
+		n.Value = s
+		return nil
+	}
+	return p.errorAt(start, "invalid value %q", tok)
+}
+
+var tomlDatetimeLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"15:04:05",
+}
+
+// tomlDatetime returns the datetime in the RFC 3339 form.
+func tomlDatetime(tok string) (string, bool) {
+	if !(len(tok) >= 8 && tok[2] == ':' || len(tok) >= 10 && tok[4] == '-') {
+		return "", false
+	}
+	s := strings.ToUpper(tok)
+	if len(s) > 10 && s[10] == ' ' {
+		s = s[:10] + "T" + s[11:]
+	}
+	for _, layout := range tomlDatetimeLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// tomlInteger returns the JSON number of the integer.
+func tomlInteger(tok string) (string, bool) {
+	if len(tok) > 2 && tok[0] == '0' && (tok[1] == 'x' || tok[1] == 'o' || tok[1] == 'b') {
+		base, digit := 16, isHexDigit
+		switch tok[1] {
+		case 'o':
+			base, digit = 8, func(c byte) bool { return c >= '0' && c <= '7' }
+		case 'b':
+			base, digit = 2, func(c byte) bool { return c == '0' || c == '1' }
+		}
+		if !validDigits(tok[2:], digit) {
+			return "", false
+		}
+		i, ok := new(big.Int).SetString(strings.ReplaceAll(tok[2:], "_", ""), base)
+		if !ok {
+			return "", false
+		}
+		return i.String(), true
+	}
+	sign, s := splitSign(tok)
+	if !validDigits(s, isDigit) || len(s) > 1 && s[0] == '0' {
+		return "", false
+	}
+	return sign + strings.ReplaceAll(s, "_", ""), true
+}
+
+// tomlFloat returns the JSON number of the float.
+func tomlFloat(tok string) (string, bool) {
+	sign, s := splitSign(tok)
+	mantissa, exp := s, ""
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa, exp = s[:i], s[i+1:]
+		if exp == "" {
+			return "", false
+		}
+	}
+	intPart, frac := mantissa, ""
+	i := strings.IndexByte(mantissa, '.')
+	if i >= 0 {
+		intPart, frac = mantissa[:i], mantissa[i+1:]
+		if !validDigits(frac, isDigit) {
+			return "", false
+		}
+	}
+	if i < 0 && exp == "" {
+		return "", false
+	}
+	if !validDigits(intPart, isDigit) || len(intPart) > 1 && intPart[0] == '0' {
+		return "", false
+	}
+	num := sign + strings.ReplaceAll(intPart, "_", "")
+	if i >= 0 {
+		num += "." + strings.ReplaceAll(frac, "_", "")
+	}
+	if exp != "" {
+		expSign, digits := splitSign(exp)
+		if !validDigits(digits, isDigit) {
+			return "", false
+		}
+		num += "e" + expSign + strings.ReplaceAll(digits, "_", "")
+	}
+	return num, true
+}
+
+// splitSign returns "-" for a negative number and the number without its sign.
+func splitSign(s string) (string, string) {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		if s[0] == '-' {
+			return "-", s[1:]
+		}
+		return "", s[1:]
+	}
+	return "", s
+}
+
+// validDigits reports whether s is digits with single underscores between them.
+func validDigits(s string, digit func(c byte) bool) bool {
+	if s == "" || s[0] == '_' || s[len(s)-1] == '_' || strings.Contains(s, "__") {
+		return false
+	}
+	for i := 0; i != len(s); i++ {
+		if s[i] != '_' && !digit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	start := p.off
+	p.off++
+	var buf []byte
+	for {
+		if p.off >= len(p.data) || p.data[p.off] == '\n' {
+			return "", p.errorAt(start, "unterminated string")
+		}
+		c := p.data[p.off]
+		switch {
+		case c == '"':
+			p.off++
+			return string(buf), nil
+		case c == '\\':
+			var err error
+			buf, err = p.escape(buf)
+			if err != nil {
+				return "", err
+			}
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return "", p.unexpected("in a string")
+		default:
+			buf = append(buf, c)
+			p.off++
+		}
+	}
+}
+
+func (p *tomlParser) literalString() (string, error) {
+	start := p.off
+	p.off++
+	for p.off < len(p.data) && p.data[p.off] != '\'' && p.data[p.off] != '\n' {
+		p.off++
+	}
+	if p.off >= len(p.data) || p.data[p.off] != '\'' {
+		return "", p.errorAt(start, "unterminated string")
+	}
+	p.off++
+	return string(p.data[start+1 : p.off-1]), nil
+}
+
+// multiLineString parses a multi-line basic or literal string, a newline right after the opening quotes is trimmed.
+func (p *tomlParser) multiLineString(quote byte) (string, error) {
+	start := p.off
+	p.off += 3
+	if p.at(p.off) == '\n' {
+		p.off++
+	} else if p.at(p.off) == '\r' && p.at(p.off+1) == '\n' {
+		p.off += 2
+	}
+	var buf []byte
+	for {
+		if p.off >= len(p.data) {
+			return "", p.errorAt(start, "unterminated string")
+		}
+		c := p.data[p.off]
+		switch {
+		case c == quote:
+			quotes := 0
+			for p.at(p.off+quotes) == quote {
+				quotes++
+			}
+			if quotes < 3 {
+				buf = append(buf, p.data[p.off:p.off+quotes]...)
+				p.off += quotes
+				continue
+			}
+			if quotes > 5 {
+				return "", p.errorAt(p.off, "too many quotes at the end of a multi-line string")
+			}
+			// Up to two quotes are allowed before the closing ones.
+			buf = append(buf, p.data[p.off:p.off+quotes-3]...)
+			p.off += quotes
+			return string(buf), nil
+		case c == '\\' && quote == '"':
+			i := p.off + 1
+			for i < len(p.data) && isBlank(p.data[i]) {
+				i++
+			}
+			if p.at(i) == '\n' {
+				// A line ending backslash trims the whitespace up to the next content.
+				p.off = i
+				for p.off < len(p.data) && (isBlank(p.data[p.off]) || p.data[p.off] == '\n') {
+					p.off++
+				}
+				continue
+			}
+			var err error
+			buf, err = p.escape(buf)
+			if err != nil {
+				return "", err
+			}
+		case c == '\r' && p.at(p.off+1) == '\n':
+			p.off++
+		case c < 0x20 && c != '\t' && c != '\n' || c == 0x7f:
+			return "", p.unexpected("in a string")
+		default:
+			buf = append(buf, c)
+			p.off++
+		}
+	}
+}
+
+// escape appends the character of the escape sequence of a basic string.
+func (p *tomlParser) escape(buf []byte) ([]byte, error) {
+	start := p.off
+	p.off++
+	size := 0
+	switch c := p.at(p.off); c {
+	case 'b':
+		buf = append(buf, '\b')
+	case 't':
+		buf = append(buf, '\t')
+	case 'n':
+		buf = append(buf, '\n')
+	case 'f':
+		buf = append(buf, '\f')
+	case 'r':
+		buf = append(buf, '\r')
+	case 'e':
+		buf = append(buf, 0x1b)
+	case '"', '\\':
+		buf = append(buf, c)
+	case 'u':
+		size = 4
+	case 'U':
+		size = 8
+	default:
+		return nil, p.errorAt(start, "invalid escape in a string")
+	}
+	p.off++
+	if size == 0 {
+		return buf, nil
+	}
+	if p.off+size > len(p.data) {
+		return nil, p.errorAt(start, "invalid escape in a string")
+	}
+	r, err := strconv.ParseUint(string(p.data[p.off:p.off+size]), 16, 32)
+	if err != nil || !utf8.ValidRune(rune(r)) {
+		return nil, p.errorAt(start, "invalid escape in a string")
+	}
+	p.off += size
+	return appendRune(buf, rune(r)), nil
+}
+
+func (p *tomlParser) newTable(off int, state uint8) *Node {
+	n := &Node{Kind: Object, Source: p.src, Offset: off, Members: []Member{}}
+	p.tables[n] = &tomlTable{state: state, index: map[string]int{}}
+	return n
+}
+
+// member returns the value of the key in the table, nil if there is none.
+func (p *tomlParser) member(t *Node, key string) *Node {
+	i, ok := p.tables[t].index[key]
+	if !ok {
+		return nil
+	}
+	return t.Members[i].Value
+}
+
+func (p *tomlParser) add(t *Node, k tomlKey, v *Node) {
+	p.tables[t].index[k.name] = len(t.Members)
+	t.Members = append(t.Members, Member{Key: k.name, KeyOffset: k.off, Value: v})
+}
+
+// skipSpace skips the blanks on the line.
+func (p *tomlParser) skipSpace() {
+	for p.off < len(p.data) && (p.data[p.off] == ' ' || p.data[p.off] == '\t') {
+		p.off++
+	}
+}
+
+// skipComment skips the comment at the offset up to the line end.
+func (p *tomlParser) skipComment() error {
+	if p.at(p.off) != '#' {
+		return nil
+	}
+	for p.off < len(p.data) && p.data[p.off] != '\n' {
+		c := p.data[p.off]
+		if c < 0x20 && c != '\t' && !(c == '\r' && p.at(p.off+1) == '\n') || c == 0x7f {
+			return p.unexpected("in a comment")
+		}
+		p.off++
+	}
+	return nil
+}
+
+// skipBlank skips the blanks, newlines and comments in an array or an inline table.
+func (p *tomlParser) skipBlank() error {
+	for {
+		p.skipSpace()
+		err := p.skipComment()
+		if err != nil {
+			return err
+		}
+		switch {
+		case p.at(p.off) == '\n':
+			p.off++
+		case p.at(p.off) == '\r' && p.at(p.off+1) == '\n':
+			p.off += 2
+		default:
+			return nil
+		}
+	}
+}
+
+// endLine checks that nothing but a comment follows on the line and skips the newline.
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	err := p.skipComment()
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.off >= len(p.data):
+	case p.data[p.off] == '\n':
+		p.off++
+	case p.data[p.off] == '\r' && p.at(p.off+1) == '\n':
+		p.off += 2
+	default:
+		return p.unexpected("at the end of the line")
+	}
+	return nil
+}
+
+func isBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || c == '_' || c == '-'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
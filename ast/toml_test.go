@@ -0,0 +1,153 @@
+package ast
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want string
+	}{
+		{name: "empty", toml: "", want: `{}`},
+		{
+			name: "values",
+			toml: `# comment
+int = +1_000
+hex = 0xdead_beef
+oct = 0o755
+bin = 0b1101
+float = -6.626e-34
+exp = 5e+22
+inf = inf
+bool = true
+str = "tab\t\u00e9 \"q\""
+lit = 'C:\path'
+odt = 1979-05-27 07:32:00z
+ldt = 1979-05-27T07:32:00.999
+date = 1979-05-27
+time = 07:32:00
+arr = [ 1, [2, "3"], { a = 1 }, ]
+multi = [
+  1, # one
+  2,
+]
+`,
+			want: `{"int":1000,"hex":3735928559,"oct":493,"bin":13,"float":-6.626e-34,"exp":5e22,"inf":"inf","bool":true,` +
+				`"str":"tab\té \"q\"","lit":"C:\\path","odt":"1979-05-27T07:32:00Z","ldt":"1979-05-27T07:32:00.999",` +
+				`"date":"1979-05-27","time":"07:32:00","arr":[1,[2,"3"],{"a":1}],"multi":[1,2]}`,
+		},
+		{
+			name: "multi-line strings",
+			toml: "a = \"\"\"\nline 1\nline 2 \\\n   joined\"\"\"\nb = '''\n\\n raw'''\nc = \"\"\"quote\"\"\"\"\"\n",
+			want: `{"a":"line 1\nline 2 joined","b":"\\n raw","c":"quote\"\""}`,
+		},
+		{
+			name: "tables",
+			toml: `title = "t"
+"@kind" = "app"
+
+[server]
+"@kind" = "http"
+port = 80
+tls.cert = "a.pem"
+tls.key = "a.key"
+
+[server.limits]
+rate = 10
+
+[a.b.c]
+d = 1
+
+[a]
+e = 2
+
+[[handlers]]
+name = "a"
+
+[[handlers]]
+name = "b"
+[handlers.opts]
+x = 1
+
+[[handlers.routes]]
+path = "/"
+
+[inline]
+point = { x = 1, y.z = 2 }
+`,
+			want: `{"title":"t","@kind":"app",` +
+				`"server":{"@kind":"http","port":80,"tls":{"cert":"a.pem","key":"a.key"},"limits":{"rate":10}},` +
+				`"a":{"b":{"c":{"d":1}},"e":2},` +
+				`"handlers":[{"name":"a"},{"name":"b","opts":{"x":1},"routes":[{"path":"/"}]}],` +
+				`"inline":{"point":{"x":1,"y":{"z":2}}}}`,
+		},
+		{
+			name: "crlf",
+			toml: "a = 1\r\n[b]\r\nc = 'x' # c\r\n",
+			want: `{"a":1,"b":{"c":"x"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := ParseTOML(&Source{Data: []byte(tt.toml)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want interface{}
+			err = json.Unmarshal([]byte(tt.want), &want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.Interface(); !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseTOML() got = %s, want %s", n.Bytes(), tt.want)
+			}
+			if got := string(n.Bytes()); got != tt.want {
+				t.Errorf("Bytes() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLError(t *testing.T) {
+	tests := []struct {
+		toml string
+		want string
+	}{
+		{toml: "a = 1\na = 2", want: "2:1"},
+		{toml: "a = 1\n[a]", want: "2:2"},
+		{toml: "[a]\n[a]", want: "2:2"},
+		{toml: "[a]\nb.c = 1\n[a.b]", want: "3:4"},
+		{toml: "a = {b = 1}\n[a.c]", want: "2:2"},
+		{toml: "a = [1]\n[[a]]", want: "2:3"},
+		{toml: "[[a]]\n[a]", want: "2:2"},
+		{toml: "a = 1 b = 2", want: "1:7"},
+		{toml: "a = 012", want: "1:5"},
+		{toml: "a = 1__0", want: "1:5"},
+		{toml: "big = 99999999999999999999", want: "1:7"},
+		{toml: "big = 0x8000000000000000", want: "1:7"},
+		{toml: "a = \"x", want: "1:5"},
+		{toml: "a = [1 2]", want: "1:8"},
+		{toml: "a = {b = 1 c = 2}", want: "1:12"},
+		{toml: "a = 1979-13-27", want: "1:5"},
+		{toml: "a", want: "1:2"},
+		{toml: "= 1", want: "1:1"},
+		{toml: "[a", want: "1:3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.toml, func(t *testing.T) {
+			_, err := ParseTOML(&Source{Name: "test.toml", Data: []byte(tt.toml)})
+			e, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("ParseTOML() error = %v, want a syntax error", err)
+			}
+			if got := strings.TrimPrefix(e.Pos.String(), "test.toml:"); got != tt.want {
+				t.Errorf("ParseTOML() error at %s: %v, want at %s", got, err, tt.want)
+			}
+		})
+	}
+}
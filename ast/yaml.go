@@ -0,0 +1,1345 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseYAML parses the YAML document in the source into a tree of nodes,
+// an empty input is null and a stream of more than one document is an error.
+// See YAMLStream for the subset of YAML supported.
+func ParseYAML(src *Source) (*Node, error) {
+	s := NewYAMLStream(src)
+	n, err := s.Next()
+	if err == io.EOF {
+		return &Node{Kind: Null, Source: src}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	off := s.p.off
+	_, err = s.Next()
+	if err == nil {
+		return nil, &SyntaxError{Pos: src.Position(off), Msg: "expected a single YAML document"}
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return n, nil
+}
+
+// ParseYAMLStream parses all the documents of the YAML stream in the source.
+func ParseYAMLStream(src *Source) ([]*Node, error) {
+	s := NewYAMLStream(src)
+	var docs []*Node
+	for {
+		n, err := s.Next()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, n)
+	}
+}
+
+// YAMLStream parses the documents of a YAML 1.2 stream one at a time.
+//
+// Block and flow collections, all the scalar styles, anchors and aliases, the merge key "<<"
+// and the tags of the core schema are supported, the values of an alias are shared with its anchor.
+// Plain scalars are resolved with the core schema, the numbers are normalized into JSON numbers,
+// .inf and .nan have no JSON form and stay strings. Complex keys with "?" and other tags are errors.
+type YAMLStream struct {
+	p   yamlParser
+	err error
+}
+
+// NewYAMLStream returns a stream of the documents in the source.
+func NewYAMLStream(src *Source) *YAMLStream {
+	s := &YAMLStream{
+		p: yamlParser{src: src, data: src.Data},
+	}
+	if bytes.HasPrefix(s.p.data, []byte("\xef\xbb\xbf")) {
+		s.p.off = 3
+	}
+	return s
+}
+
+// Next returns the next document of the stream, it returns io.EOF at the end of the stream.
+func (s *YAMLStream) Next() (*Node, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	n, err := s.p.document()
+	if err != nil {
+		s.err = err
+		return nil, err
+	}
+	if n == nil {
+		s.err = io.EOF
+		return nil, io.EOF
+	}
+	return n, nil
+}
+
+type yamlParser struct {
+	src     *Source
+	data    []byte
+	off     int
+	depth   int
+	anchors map[string]*Node
+
+	// nodes is the count of the nodes parsed, aliased of the nodes the aliases expand to,
+	// sizes the expanded size of the anchored nodes.
+	nodes   int
+	aliased int
+	sizes   map[*Node]int
+}
+
+func (p *yamlParser) errorAt(off int, format string, args ...interface{}) error {
+	return &SyntaxError{
+		Pos: p.src.Position(off),
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *yamlParser) unexpected(context string) error {
+	if p.off >= len(p.data) {
+		return p.errorAt(p.off, "unexpected end of YAML input %s", context)
+	}
+	return p.errorAt(p.off, "unexpected character %s %s", quoteChar(p.data[p.off]), context)
+}
+
+// document returns the next document, nil at the end of the stream.
+func (p *yamlParser) document() (*Node, error) {
+	for {
+		err := p.skipLines()
+		if err != nil {
+			return nil, err
+		}
+		if p.off >= len(p.data) {
+			return nil, nil
+		}
+		switch {
+		case p.data[p.off] == '%' && p.col(p.off) == 0:
+			// Directives are ignored.
+			p.skipLine()
+			continue
+		case p.isMarker("..."):
+			p.off += 3
+			continue
+		}
+		break
+	}
+
+	if p.isMarker("---") {
+		p.off += 3
+	}
+	p.anchors = map[string]*Node{}
+	p.nodes, p.aliased = 0, 0
+	p.sizes = map[*Node]int{}
+	n, err := p.node(-1, false, false)
+	if err != nil {
+		return nil, err
+	}
+	err = p.endLine()
+	if err != nil {
+		return nil, err
+	}
+	err = p.skipLines()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.off >= len(p.data), p.isMarker("---"):
+	case p.isMarker("..."):
+		p.off += 3
+		err = p.endLine()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.unexpected("after the document")
+	}
+	return n, nil
+}
+
+// node parses a block node, its content is indented more than parent, or as much for a sequence if compactSeq is set.
+// inline is set for the value of a mapping on the line of its key, which cannot be a block collection.
+// The offset is left after the content of the node.
+func (p *yamlParser) node(parent int, compactSeq bool, inline bool) (*Node, error) {
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+	if p.depth > maxDepth {
+		return nil, p.errorAt(p.off, "exceeded max depth")
+	}
+	p.nodes++
+
+	start := p.off
+	anchor, tag := "", ""
+	for {
+		p.skipInline()
+		if p.atLineEnd() {
+			// The content is on the next lines.
+			end := p.off
+			err := p.skipLines()
+			if err != nil {
+				return nil, err
+			}
+			inline = false
+			if p.off >= len(p.data) || p.isDocumentMarker() {
+				p.off = end
+				return p.empty(start, anchor, tag)
+			}
+			col := p.col(p.off)
+			if col <= parent && !(compactSeq && col == parent && p.isSeqEntry(p.off)) {
+				p.off = end
+				return p.empty(start, anchor, tag)
+			}
+		}
+		switch p.data[p.off] {
+		case '&':
+			if anchor != "" {
+				return nil, p.errorAt(p.off, "more than one anchor")
+			}
+			p.off++
+			anchor = p.name()
+			if anchor == "" {
+				return nil, p.unexpected("in an anchor")
+			}
+			continue
+		case '!':
+			if tag != "" {
+				return nil, p.errorAt(p.off, "more than one tag")
+			}
+			tag = p.tag()
+			continue
+		}
+		break
+	}
+
+	off := p.off
+	n := &Node{Source: p.src, Offset: off}
+	var err error
+	switch c := p.data[off]; {
+	case c == '*':
+		if anchor != "" || tag != "" {
+			return nil, p.errorAt(off, "an alias cannot have an anchor or a tag")
+		}
+		return p.alias()
+	case c == '-' && p.isWS(off+1):
+		if inline {
+			return nil, p.errorAt(off, "block sequence entries are not allowed in this context")
+		}
+		err = p.sequence(n, p.col(off))
+	case c == '?' && p.isWS(off+1):
+		return nil, p.errorAt(off, "complex mapping keys are not supported")
+	case c == '|' || c == '>':
+		err = p.blockScalar(n, parent)
+		if err == nil {
+			err = p.applyTag(n, tag, false, n.Value)
+		}
+	case c == '[' || c == '{':
+		n, err = p.flowNode()
+		if err == nil {
+			err = p.applyTag(n, tag, false, "")
+		}
+	default:
+		err = p.scalarOrMapping(n, parent, inline, tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" {
+		p.anchors[anchor] = n
+	}
+	return n, nil
+}
+
+// empty returns the null node of an absent value.
+func (p *yamlParser) empty(off int, anchor, tag string) (*Node, error) {
+	n := &Node{Kind: Null, Source: p.src, Offset: off}
+	err := p.applyTag(n, tag, true, "")
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" {
+		p.anchors[anchor] = n
+	}
+	return n, nil
+}
+
+func (p *yamlParser) alias() (*Node, error) {
+	off := p.off
+	p.off++
+	name := p.name()
+	if name == "" {
+		return nil, p.unexpected("in an alias")
+	}
+	n, ok := p.anchors[name]
+	if !ok {
+		return nil, p.errorAt(off, "unknown anchor %q", name)
+	}
+	p.aliased += p.size(n)
+	total := p.nodes + p.aliased
+	if p.aliased > 100 && total > 1000 && float64(p.aliased)/float64(total) > allowedAliasRatio(total) {
+		return nil, p.errorAt(off, "exceeded the alias expansion budget")
+	}
+	return n, nil
+}
+
+// size returns the count of the nodes n expands to, with the values of the aliases in it.
+func (p *yamlParser) size(n *Node) int {
+	if s, ok := p.sizes[n]; ok {
+		return s
+	}
+	s := 1
+	for _, e := range n.Elems {
+		s += p.size(e)
+	}
+	for _, m := range n.Members {
+		s += p.size(m.Value)
+	}
+	p.sizes[n] = s
+	return s
+}
+
+// allowedAliasRatio returns the share of the nodes that may come from aliases, the same as go-yaml,
+// it lets small documents use aliases freely and stops the expansion of nested aliases,
+// such as the billion laughs attack, from growing without bound.
+func allowedAliasRatio(total int) float64 {
+	switch {
+	case total <= 400000:
+		return 0.99
+	case total >= 4000000:
+		return 0.10
+	}
+	return 0.99 - 0.89*float64(total-400000)/3600000
+}
+
+// scalarOrMapping parses a scalar, or a block mapping if it is followed by ":".
+func (p *yamlParser) scalarOrMapping(n *Node, parent int, inline bool, tag string) error {
+	off := p.off
+	c := p.data[off]
+	if c == '"' || c == '\'' {
+		s, err := p.quoted()
+		if err != nil {
+			return err
+		}
+		if p.keyFollows() {
+			if inline {
+				return p.errorAt(p.off-1, "mapping values are not allowed in this context")
+			}
+			return p.mapping(n, p.col(off), s, off, false, tag)
+		}
+		n.Kind = String
+		n.Value = s
+		return p.applyTag(n, tag, false, s)
+	}
+
+	s, err := p.plainLine(false)
+	if err != nil {
+		return err
+	}
+	if p.keyFollows() {
+		if inline {
+			return p.errorAt(p.off-1, "mapping values are not allowed in this context")
+		}
+		return p.mapping(n, p.col(off), s, off, s == "<<", tag)
+	}
+	s, err = p.plainLines(s, parent)
+	if err != nil {
+		return err
+	}
+	resolvePlain(n, s)
+	return p.applyTag(n, tag, true, s)
+}
+
+// keyFollows reports whether a ":" separating a key from its value follows on the line, skipping it.
+func (p *yamlParser) keyFollows() bool {
+	i := p.off
+	for i < len(p.data) && (p.data[i] == ' ' || p.data[i] == '\t') {
+		i++
+	}
+	if i < len(p.data) && p.data[i] == ':' && p.isWS(i+1) {
+		p.off = i + 1
+		return true
+	}
+	return false
+}
+
+// mapping parses a block mapping at the indentation, the offset is after the ":" of the first key.
+func (p *yamlParser) mapping(n *Node, ind int, key string, keyOff int, merge bool, tag string) error {
+	n.Kind = Object
+	n.Members = []Member{}
+	n.Offset = keyOff
+	m := members{}
+	for {
+		value, err := p.node(ind, true, true)
+		if err != nil {
+			return err
+		}
+		err = p.addMember(&m, n, key, keyOff, merge, value)
+		if err != nil {
+			return err
+		}
+		err = p.endLine()
+		if err != nil {
+			return err
+		}
+
+		end := p.off
+		err = p.skipLines()
+		if err != nil {
+			return err
+		}
+		if p.off >= len(p.data) || p.isDocumentMarker() || p.col(p.off) < ind {
+			p.off = end
+			break
+		}
+		if p.col(p.off) > ind {
+			return p.errorAt(p.off, "bad indentation of a mapping entry")
+		}
+		key, keyOff, merge, err = p.key()
+		if err != nil {
+			return err
+		}
+	}
+	m.apply(n)
+	return p.applyTag(n, tag, false, "")
+}
+
+// key parses the key of a block mapping entry and its ":".
+func (p *yamlParser) key() (string, int, bool, error) {
+	off := p.off
+	switch c := p.data[off]; {
+	case c == '"' || c == '\'':
+		s, err := p.quoted()
+		if err != nil {
+			return "", 0, false, err
+		}
+		if !p.keyFollows() {
+			return "", 0, false, p.errorAt(off, "could not find expected ':'")
+		}
+		return s, off, false, nil
+	case c == '?' && p.isWS(off+1):
+		return "", 0, false, p.errorAt(off, "complex mapping keys are not supported")
+	case c == '-' && p.isWS(off+1):
+		return "", 0, false, p.errorAt(off, "expected a mapping key, found a sequence entry")
+	case c == '*' || c == '&' || c == '!' || c == '[' || c == '{':
+		return "", 0, false, p.errorAt(off, "only scalars are supported as mapping keys")
+	}
+	s, err := p.plainLine(false)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if !p.keyFollows() {
+		return "", 0, false, p.errorAt(off, "could not find expected ':'")
+	}
+	return s, off, s == "<<", nil
+}
+
+// members is the state of the members of a mapping being parsed.
+type members struct {
+	keys map[string]bool
+	// merges is the indexes of the members of the merge key.
+	merges []int
+}
+
+func (p *yamlParser) addMember(m *members, n *Node, key string, keyOff int, merge bool, value *Node) error {
+	if m.keys == nil {
+		m.keys = map[string]bool{}
+	}
+	if merge {
+		ok := value.Kind == Object
+		if value.Kind == Array {
+			ok = true
+			for _, elem := range value.Elems {
+				if elem.Kind != Object {
+					ok = false
+				}
+			}
+		}
+		if !ok {
+			return p.errorAt(value.Offset, "the merge key needs a mapping or a sequence of mappings")
+		}
+		m.merges = append(m.merges, len(n.Members))
+	} else {
+		if m.keys[key] {
+			return p.errorAt(keyOff, "duplicate key %q", key)
+		}
+		m.keys[key] = true
+	}
+	n.Members = append(n.Members, Member{Key: key, KeyOffset: keyOff, Value: value})
+	return nil
+}
+
+// apply replaces the merge keys with the members of the mappings they hold,
+// the keys of the mapping and of the mappings merged before take precedence.
+func (m *members) apply(n *Node) {
+	if len(m.merges) == 0 {
+		return
+	}
+	out := make([]Member, 0, len(n.Members))
+	next := 0
+	for i, member := range n.Members {
+		if next == len(m.merges) || m.merges[next] != i {
+			out = append(out, member)
+			continue
+		}
+		next++
+		sources := []*Node{member.Value}
+		if member.Value.Kind == Array {
+			sources = member.Value.Elems
+		}
+		for _, src := range sources {
+			for _, sm := range src.Members {
+				if !m.keys[sm.Key] {
+					m.keys[sm.Key] = true
+					out = append(out, sm)
+				}
+			}
+		}
+	}
+	n.Members = out
+}
+
+// sequence parses a block sequence at the indentation.
+func (p *yamlParser) sequence(n *Node, ind int) error {
+	n.Kind = Array
+	n.Elems = []*Node{}
+	for {
+		p.off++
+		elem, err := p.node(ind, false, false)
+		if err != nil {
+			return err
+		}
+		n.Elems = append(n.Elems, elem)
+		err = p.endLine()
+		if err != nil {
+			return err
+		}
+
+		end := p.off
+		err = p.skipLines()
+		if err != nil {
+			return err
+		}
+		if p.off >= len(p.data) || p.isDocumentMarker() || p.col(p.off) < ind {
+			p.off = end
+			return nil
+		}
+		if p.col(p.off) > ind {
+			return p.errorAt(p.off, "bad indentation of a sequence entry")
+		}
+		if !p.isSeqEntry(p.off) {
+			p.off = end
+			return nil
+		}
+	}
+}
+
+// blockScalar parses a literal "|" or a folded ">" scalar, its lines are indented more than parent.
+func (p *yamlParser) blockScalar(n *Node, parent int) error {
+	folded := p.data[p.off] == '>'
+	p.off++
+	chomp := byte(0)
+	indent := 0
+	for i := 0; i != 2 && p.off < len(p.data); i++ {
+		switch c := p.data[p.off]; {
+		case (c == '+' || c == '-') && chomp == 0:
+			chomp = c
+			p.off++
+		case c >= '1' && c <= '9' && indent == 0:
+			indent = int(c - '0')
+			p.off++
+		}
+	}
+	err := p.endLine()
+	if err != nil {
+		return err
+	}
+	end := p.off
+	p.skipLine()
+
+	content := -1
+	if indent != 0 {
+		content = indent
+		if parent > 0 {
+			content += parent
+		}
+	}
+	type line struct {
+		text  string
+		empty bool
+	}
+	var lines []line
+	for pos := p.off; pos < len(p.data); {
+		j := pos
+		for j < len(p.data) && p.data[j] == ' ' {
+			j++
+		}
+		lineEnd := bytes.IndexByte(p.data[j:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(p.data)
+		} else {
+			lineEnd += j
+		}
+		blank := len(bytes.Trim(p.data[j:lineEnd], " \t\r")) == 0
+		switch {
+		case content < 0 && blank:
+			lines = append(lines, line{empty: true})
+		case content < 0 && j-pos <= parent, pos == j && p.isMarkerAt(pos, "---"), pos == j && p.isMarkerAt(pos, "..."):
+			lineEnd = -1
+		case content < 0:
+			content = j - pos
+			fallthrough
+		case j-pos >= content:
+			text := strings.TrimSuffix(string(p.data[pos+content:lineEnd]), "\r")
+			if text == "" {
+				lines = append(lines, line{empty: true})
+				break
+			}
+			lines = append(lines, line{text: text})
+			end = lineEnd
+		case blank:
+			lines = append(lines, line{empty: true})
+		default:
+			lineEnd = -1
+		}
+		if lineEnd < 0 {
+			break
+		}
+		pos = lineEnd + 1
+	}
+	p.off = end
+
+	buf := strings.Builder{}
+	empties := 0
+	started := false
+	moreIndented := false
+	for _, l := range lines {
+		if l.empty {
+			empties++
+			continue
+		}
+		more := strings.HasPrefix(l.text, " ") || strings.HasPrefix(l.text, "\t")
+		switch {
+		case !started:
+			buf.WriteString(strings.Repeat("\n", empties))
+		case !folded || more || moreIndented:
+			buf.WriteString(strings.Repeat("\n", empties+1))
+		case empties == 0:
+			buf.WriteByte(' ')
+		default:
+			buf.WriteString(strings.Repeat("\n", empties))
+		}
+		buf.WriteString(l.text)
+		started = true
+		moreIndented = more
+		empties = 0
+	}
+	switch {
+	case chomp == '+' && started:
+		buf.WriteString(strings.Repeat("\n", empties+1))
+	case chomp == '+':
+		buf.WriteString(strings.Repeat("\n", empties))
+	case chomp == 0 && started:
+		buf.WriteByte('\n')
+	}
+	n.Kind = String
+	n.Value = buf.String()
+	return nil
+}
+
+// plainLine returns the text of the plain scalar on the line, up to a ": ", a comment or the line end,
+// in a flow collection also up to a flow indicator.
+func (p *yamlParser) plainLine(flow bool) (string, error) {
+	start := p.off
+	switch c := p.data[start]; c {
+	case ',', ']', '}', '#', '%', '@', '`', '|', '>', '&', '*', '!', '[', '{', '"', '\'':
+		return "", p.unexpected("that cannot start any token")
+	case '-', '?', ':':
+		if p.isWS(start+1) || flow && p.isFlowIndicator(start+1) {
+			return "", p.unexpected("that cannot start any token")
+		}
+	}
+	end := start
+	i := start
+	for ; i < len(p.data); i++ {
+		c := p.data[i]
+		if c == '\n' || c == '#' && i > start && isBlank(p.data[i-1]) {
+			break
+		}
+		if c == ':' && (p.isWS(i+1) || flow && p.isFlowIndicator(i+1)) {
+			break
+		}
+		if flow && p.isFlowIndicator(i) {
+			break
+		}
+		if !isBlank(c) {
+			end = i + 1
+		}
+	}
+	p.off = end
+	return string(p.data[start:end]), nil
+}
+
+// plainLines continues the plain scalar on the lines after the first one, indented more than parent.
+func (p *yamlParser) plainLines(s string, parent int) (string, error) {
+	buf := strings.Builder{}
+	buf.WriteString(s)
+	for {
+		i := p.off
+		for i < len(p.data) && isBlank(p.data[i]) {
+			i++
+		}
+		if i >= len(p.data) || p.data[i] != '\n' {
+			return buf.String(), nil
+		}
+		breaks := 0
+		for i < len(p.data) && p.data[i] == '\n' {
+			breaks++
+			i++
+			for i < len(p.data) && isBlank(p.data[i]) {
+				i++
+			}
+		}
+		if i >= len(p.data) || p.data[i] == '#' || p.col(i) <= parent || p.isMarkerAt(i, "---") || p.isMarkerAt(i, "...") {
+			return buf.String(), nil
+		}
+		p.off = i
+		text, err := p.plainLine(false)
+		if err != nil {
+			return "", err
+		}
+		if p.keyFollows() {
+			return "", p.errorAt(p.off-1, "mapping values are not allowed in this context")
+		}
+		if breaks == 1 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteString(strings.Repeat("\n", breaks-1))
+		}
+		buf.WriteString(text)
+	}
+}
+
+// flowNode parses a node in a flow collection, or a flow collection in a block.
+func (p *yamlParser) flowNode() (*Node, error) {
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+	if p.depth > maxDepth {
+		return nil, p.errorAt(p.off, "exceeded max depth")
+	}
+	p.nodes++
+
+	anchor, tag := "", ""
+	for {
+		err := p.skipFlowSpace()
+		if err != nil {
+			return nil, err
+		}
+		if p.off >= len(p.data) {
+			return nil, p.unexpected("in a flow collection")
+		}
+		switch p.data[p.off] {
+		case '&':
+			p.off++
+			anchor = p.name()
+			continue
+		case '!':
+			tag = p.tag()
+			continue
+		}
+		break
+	}
+
+	off := p.off
+	n := &Node{Source: p.src, Offset: off}
+	var err error
+	switch p.data[off] {
+	case '*':
+		if anchor != "" || tag != "" {
+			return nil, p.errorAt(off, "an alias cannot have an anchor or a tag")
+		}
+		return p.alias()
+	case '[':
+		err = p.flowSequence(n)
+		if err == nil {
+			err = p.applyTag(n, tag, false, "")
+		}
+	case '{':
+		err = p.flowMapping(n)
+		if err == nil {
+			err = p.applyTag(n, tag, false, "")
+		}
+	case ',', ']', '}':
+		// An absent value.
+		n.Kind = Null
+		err = p.applyTag(n, tag, true, "")
+	case '"', '\'':
+		n.Kind = String
+		n.Value, err = p.quoted()
+		if err == nil {
+			err = p.applyTag(n, tag, false, n.Value)
+		}
+	default:
+		var s string
+		s, err = p.plainFlow()
+		if err == nil {
+			resolvePlain(n, s)
+			err = p.applyTag(n, tag, true, s)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" {
+		p.anchors[anchor] = n
+	}
+	return n, nil
+}
+
+func (p *yamlParser) flowSequence(n *Node) error {
+	n.Kind = Array
+	n.Elems = []*Node{}
+	p.off++
+	for {
+		err := p.skipFlowSpace()
+		if err != nil {
+			return err
+		}
+		if p.off < len(p.data) && p.data[p.off] == ']' {
+			p.off++
+			return nil
+		}
+		elem, err := p.flowNode()
+		if err != nil {
+			return err
+		}
+		n.Elems = append(n.Elems, elem)
+		err = p.skipFlowSpace()
+		if err != nil {
+			return err
+		}
+		switch {
+		case p.off >= len(p.data):
+			return p.unexpected("in a flow sequence")
+		case p.data[p.off] == ',':
+			p.off++
+		case p.data[p.off] == ']':
+			p.off++
+			return nil
+		case p.data[p.off] == ':':
+			return p.errorAt(p.off, "mappings in flow sequences are not supported")
+		default:
+			return p.unexpected("after a flow sequence entry")
+		}
+	}
+}
+
+func (p *yamlParser) flowMapping(n *Node) error {
+	n.Kind = Object
+	n.Members = []Member{}
+	m := members{}
+	p.off++
+	for {
+		err := p.skipFlowSpace()
+		if err != nil {
+			return err
+		}
+		if p.off >= len(p.data) {
+			return p.unexpected("in a flow mapping")
+		}
+		if p.data[p.off] == '}' {
+			p.off++
+			break
+		}
+
+		keyOff := p.off
+		var key string
+		merge := false
+		switch c := p.data[keyOff]; {
+		case c == '"' || c == '\'':
+			key, err = p.quoted()
+		case c == '?' && p.isWS(keyOff+1):
+			return p.errorAt(keyOff, "complex mapping keys are not supported")
+		case c == '*' || c == '&' || c == '!' || c == '[' || c == '{':
+			return p.errorAt(keyOff, "only scalars are supported as mapping keys")
+		default:
+			key, err = p.plainFlow()
+			merge = key == "<<"
+		}
+		if err != nil {
+			return err
+		}
+		err = p.skipFlowSpace()
+		if err != nil {
+			return err
+		}
+		var value *Node
+		if p.off < len(p.data) && p.data[p.off] == ':' {
+			p.off++
+			value, err = p.flowNode()
+			if err != nil {
+				return err
+			}
+		} else {
+			value = &Node{Kind: Null, Source: p.src, Offset: p.off}
+		}
+		err = p.addMember(&m, n, key, keyOff, merge, value)
+		if err != nil {
+			return err
+		}
+
+		err = p.skipFlowSpace()
+		if err != nil {
+			return err
+		}
+		switch {
+		case p.off >= len(p.data):
+			return p.unexpected("in a flow mapping")
+		case p.data[p.off] == ',':
+			p.off++
+		case p.data[p.off] == '}':
+			p.off++
+			m.apply(n)
+			return nil
+		default:
+			return p.unexpected("after a flow mapping entry")
+		}
+	}
+	m.apply(n)
+	return nil
+}
+
+// plainFlow parses a plain scalar in a flow collection, it may span lines.
+func (p *yamlParser) plainFlow() (string, error) {
+	s, err := p.plainLine(true)
+	if err != nil {
+		return "", err
+	}
+	buf := strings.Builder{}
+	buf.WriteString(s)
+	for {
+		i := p.off
+		for i < len(p.data) && isBlank(p.data[i]) {
+			i++
+		}
+		if i >= len(p.data) || p.data[i] != '\n' {
+			return buf.String(), nil
+		}
+		breaks := 0
+		for i < len(p.data) && p.data[i] == '\n' {
+			breaks++
+			i++
+			for i < len(p.data) && isBlank(p.data[i]) {
+				i++
+			}
+		}
+		if i >= len(p.data) || p.data[i] == '#' || p.isFlowIndicator(i) || p.data[i] == ':' && (p.isWS(i+1) || p.isFlowIndicator(i+1)) {
+			return buf.String(), nil
+		}
+		p.off = i
+		text, err := p.plainLine(true)
+		if err != nil {
+			return "", err
+		}
+		if breaks == 1 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteString(strings.Repeat("\n", breaks-1))
+		}
+		buf.WriteString(text)
+	}
+}
+
+// quoted parses a single or double quoted scalar.
+func (p *yamlParser) quoted() (string, error) {
+	start := p.off
+	quote := p.data[start]
+	p.off++
+	var buf []byte
+	for {
+		if p.off >= len(p.data) {
+			return "", p.errorAt(start, "found unexpected end of stream while scanning a quoted scalar")
+		}
+		c := p.data[p.off]
+		switch {
+		case c == quote && quote == '\'' && p.off+1 < len(p.data) && p.data[p.off+1] == '\'':
+			buf = append(buf, '\'')
+			p.off += 2
+		case c == quote:
+			p.off++
+			return string(buf), nil
+		case c == '\\' && quote == '"':
+			var err error
+			buf, err = p.escape(buf)
+			if err != nil {
+				return "", err
+			}
+		case c == '\n' || c == '\r' && p.off+1 < len(p.data) && p.data[p.off+1] == '\n':
+			// A line break is folded into a space, or kept if followed by empty lines.
+			for len(buf) != 0 && isBlank(buf[len(buf)-1]) {
+				buf = buf[:len(buf)-1]
+			}
+			breaks := p.skipBreaks()
+			if breaks == 1 {
+				buf = append(buf, ' ')
+			} else {
+				buf = append(buf, strings.Repeat("\n", breaks-1)...)
+			}
+		default:
+			buf = append(buf, c)
+			p.off++
+		}
+	}
+}
+
+// skipBreaks skips the line breaks and the blanks around them, returning the number of breaks.
+func (p *yamlParser) skipBreaks() int {
+	breaks := 0
+	for p.off < len(p.data) {
+		switch p.data[p.off] {
+		case '\n':
+			breaks++
+		case ' ', '\t', '\r':
+		default:
+			return breaks
+		}
+		p.off++
+	}
+	return breaks
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b",
+	' ': " ", '"': "\"", '/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+// escape appends the character of the escape sequence of a double quoted scalar.
+func (p *yamlParser) escape(buf []byte) ([]byte, error) {
+	start := p.off
+	p.off++
+	if p.off >= len(p.data) {
+		return nil, p.errorAt(start, "found unexpected end of stream while scanning a quoted scalar")
+	}
+	c := p.data[p.off]
+	if s, ok := yamlEscapes[c]; ok {
+		p.off++
+		return append(buf, s...), nil
+	}
+	size := 0
+	switch c {
+	case '\n', '\r':
+		// An escaped line break is removed with the blanks after it.
+		p.skipBreaks()
+		return buf, nil
+	case 'x':
+		size = 2
+	case 'u':
+		size = 4
+	case 'U':
+		size = 8
+	default:
+		return nil, p.errorAt(start, "invalid escape %s in a double quoted scalar", quoteChar(c))
+	}
+	p.off++
+	if p.off+size > len(p.data) {
+		return nil, p.errorAt(start, "invalid escape in a double quoted scalar")
+	}
+	r, err := strconv.ParseUint(string(p.data[p.off:p.off+size]), 16, 32)
+	if err != nil || !utf8.ValidRune(rune(r)) {
+		return nil, p.errorAt(start, "invalid escape in a double quoted scalar")
+	}
+	p.off += size
+	return appendRune(buf, rune(r)), nil
+}
+
+// name parses the name of an anchor or an alias.
+func (p *yamlParser) name() string {
+	start := p.off
+	for p.off < len(p.data) && !p.isWS(p.off) && !p.isFlowIndicator(p.off) {
+		p.off++
+	}
+	return string(p.data[start:p.off])
+}
+
+// tag parses a tag, the tags of the core schema are returned in the "!!str" form.
+func (p *yamlParser) tag() string {
+	start := p.off
+	for p.off < len(p.data) && !p.isWS(p.off) && !(p.data[p.off] == ',' || p.data[p.off] == ']' || p.data[p.off] == '}') {
+		p.off++
+	}
+	tag := string(p.data[start:p.off])
+	if strings.HasPrefix(tag, "!<tag:yaml.org,2002:") && strings.HasSuffix(tag, ">") {
+		tag = "!!" + tag[len("!<tag:yaml.org,2002:"):len(tag)-1]
+	}
+	return tag
+}
+
+// applyTag checks the node against the tag, s is the text of a scalar and plain is set if it is not quoted.
+func (p *yamlParser) applyTag(n *Node, tag string, plain bool, s string) error {
+	var kind Kind
+	switch tag {
+	case "":
+		return nil
+	case "!", "!!str":
+		if n.Kind == Array || n.Kind == Object {
+			return p.errorAt(n.Offset, "cannot apply the tag %s to a collection", tag)
+		}
+		if plain {
+			n.Kind = String
+			n.Value = s
+		}
+		return nil
+	case "!!int", "!!float":
+		kind = Number
+	case "!!bool":
+		kind = Bool
+	case "!!null":
+		kind = Null
+	case "!!seq":
+		kind = Array
+	case "!!map":
+		kind = Object
+	default:
+		return p.errorAt(n.Offset, "unsupported tag %s", tag)
+	}
+	if n.Kind == String && !plain {
+		// A quoted scalar with the tag of another type.
+		resolvePlain(n, s)
+	}
+	if n.Kind != kind || tag == "!!int" && strings.ContainsAny(n.Value, ".eE") {
+		return p.errorAt(n.Offset, "cannot decode %s as %s", n.Kind, tag)
+	}
+	return nil
+}
+
+// resolvePlain sets the kind and value of a plain scalar with the core schema of YAML 1.2.
+func resolvePlain(n *Node, s string) {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		n.Kind = Null
+		n.Value = ""
+		return
+	case "true", "True", "TRUE":
+		n.Kind = Bool
+		n.Value = "true"
+		return
+	case "false", "False", "FALSE":
+		n.Kind = Bool
+		n.Value = "false"
+		return
+	}
+	if num, ok := yamlNumber(s); ok {
+		n.Kind = Number
+		n.Value = num
+		return
+	}
+	n.Kind = String
+	n.Value = s
+}
+
+// yamlNumber returns the JSON number of the integer or float of the core schema.
+func yamlNumber(s string) (string, bool) {
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'o' || s[1] == 'x') {
+		base := 8
+		if s[1] == 'x' {
+			base = 16
+		}
+		i, ok := new(big.Int).SetString(s[2:], base)
+		if !ok {
+			return "", false
+		}
+		return i.String(), true
+	}
+
+	i := 0
+	sign := ""
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		if s[i] == '-' {
+			sign = "-"
+		}
+		i++
+	}
+	intStart := i
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	intPart := s[intStart:i]
+	frac := ""
+	hasFrac := false
+	if i < len(s) && s[i] == '.' {
+		hasFrac = true
+		i++
+		fracStart := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		frac = s[fracStart:i]
+	}
+	if intPart == "" && frac == "" {
+		return "", false
+	}
+	exp := ""
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		if !hasFrac && intPart == "" {
+			return "", false
+		}
+		expStart := i
+		i++
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			i++
+		}
+		digits := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == digits {
+			return "", false
+		}
+		exp = s[expStart:i]
+	}
+	if i != len(s) {
+		return "", false
+	}
+
+	intPart = strings.TrimLeft(intPart, "0")
+	if intPart == "" {
+		intPart = "0"
+	}
+	num := sign + intPart
+	if frac != "" {
+		num += "." + frac
+	}
+	return num + exp, true
+}
+
+// skipInline skips the blanks on the line.
+func (p *yamlParser) skipInline() {
+	for p.off < len(p.data) && isBlank(p.data[p.off]) {
+		p.off++
+	}
+}
+
+// atLineEnd reports whether the offset is at a comment, a line break or the end of the input.
+func (p *yamlParser) atLineEnd() bool {
+	return p.off >= len(p.data) || p.data[p.off] == '\n' || p.data[p.off] == '#'
+}
+
+// endLine checks that nothing but a comment follows on the line.
+func (p *yamlParser) endLine() error {
+	p.skipInline()
+	if !p.atLineEnd() {
+		return p.unexpected("at the end of the line")
+	}
+	return nil
+}
+
+// skipLine skips to the start of the next line.
+func (p *yamlParser) skipLine() {
+	i := bytes.IndexByte(p.data[p.off:], '\n')
+	if i < 0 {
+		p.off = len(p.data)
+		return
+	}
+	p.off += i + 1
+}
+
+// skipLines skips the blanks, comments and empty lines up to the next content,
+// the indentation of a block must not have tabs.
+func (p *yamlParser) skipLines() error {
+	for p.off < len(p.data) {
+		lineStart := p.off == 0 || p.data[p.off-1] == '\n'
+		start := p.off
+		p.skipInline()
+		if p.off < len(p.data) && p.data[p.off] == '#' {
+			p.skipLine()
+			continue
+		}
+		if p.off < len(p.data) && p.data[p.off] == '\n' {
+			p.off++
+			continue
+		}
+		if lineStart && p.off < len(p.data) && bytes.IndexByte(p.data[start:p.off], '\t') >= 0 {
+			return p.errorAt(start, "found a tab character in the indentation")
+		}
+		return nil
+	}
+	return nil
+}
+
+// skipFlowSpace skips the blanks, line breaks and comments in a flow collection.
+func (p *yamlParser) skipFlowSpace() error {
+	for p.off < len(p.data) {
+		switch c := p.data[p.off]; {
+		case isBlank(c) || c == '\n':
+			p.off++
+		case c == '#' && (p.off == 0 || p.isWS(p.off-1)):
+			p.skipLine()
+		default:
+			if p.col(p.off) == 0 && p.isDocumentMarker() {
+				return p.errorAt(p.off, "unexpected document marker in a flow collection")
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// col returns the column of the offset, from 0.
+func (p *yamlParser) col(off int) int {
+	i := off
+	for i > 0 && p.data[i-1] != '\n' {
+		i--
+	}
+	return off - i
+}
+
+func (p *yamlParser) isWS(i int) bool {
+	return i >= len(p.data) || isBlank(p.data[i]) || p.data[i] == '\n'
+}
+
+func (p *yamlParser) isFlowIndicator(i int) bool {
+	if i >= len(p.data) {
+		return false
+	}
+	switch p.data[i] {
+	case ',', '[', ']', '{', '}':
+		return true
+	}
+	return false
+}
+
+func (p *yamlParser) isSeqEntry(i int) bool {
+	return i < len(p.data) && p.data[i] == '-' && p.isWS(i+1)
+}
+
+func (p *yamlParser) isMarker(marker string) bool {
+	return p.isMarkerAt(p.off, marker)
+}
+
+// isMarkerAt reports whether the document marker "---" or "..." is at the offset, at the start of a line.
+func (p *yamlParser) isMarkerAt(i int, marker string) bool {
+	return (i == 0 || p.data[i-1] == '\n') && bytes.HasPrefix(p.data[i:], []byte(marker)) && p.isWS(i+3)
+}
+
+func (p *yamlParser) isDocumentMarker() bool {
+	return p.isMarker("---") || p.isMarker("...")
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
@@ -0,0 +1,235 @@
+package ast
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{name: "empty", yaml: "", want: `null`},
+		{name: "comment", yaml: "# nothing\n", want: `null`},
+		{name: "scalar", yaml: "hello world", want: `"hello world"`},
+		{
+			name: "core schema",
+			yaml: "a: ~\nb: null\nc: True\nd: false\ne: 012\nf: -0x1F\ng: 0o17\nh: 0x1F\ni: +1.5\nj: .5\nk: 1.\nl: 1e3\nm: .inf\nn: 1.2.3\no: '1'\np: yes\n",
+			want: `{"a":null,"b":null,"c":true,"d":false,"e":12,"f":"-0x1F","g":15,"h":31,"i":1.5,"j":0.5,"k":1,"l":1e3,"m":".inf","n":"1.2.3","o":"1","p":"yes"}`,
+		},
+		{
+			name: "block",
+			yaml: `
+server:
+  name: app  # comment
+  ports:
+  - 80
+  - 443
+  tls:
+    - cert: a.pem
+      key: a.key
+    -
+      cert: b.pem
+  empty:
+list:
+  - - 1
+    - 2
+  - [3, 4]
+`,
+			want: `{"server":{"name":"app","ports":[80,443],"tls":[{"cert":"a.pem","key":"a.key"},{"cert":"b.pem"}],"empty":null},"list":[[1,2],[3,4]]}`,
+		},
+		{
+			name: "flow",
+			yaml: `{"a": 1, b: [x, 'y', "z"], c: {d: e, f}, g: [], h: {}, url: http://x:80/a,
+  multi: one
+    two}`,
+			want: `{"a":1,"b":["x","y","z"],"c":{"d":"e","f":null},"g":[],"h":{},"url":"http://x:80/a","multi":"one two"}`,
+		},
+		{
+			name: "quoted",
+			yaml: `a: 'it''s'
+b: "tab\tnew\nline \u00e9 \x41 \"q\""
+c: "folded
+  line
+
+  break"
+d: "joined\
+  "
+e: 'a
+  b'
+`,
+			want: `{"a":"it's","b":"tab\tnew\nline é A \"q\"","c":"folded line\nbreak","d":"joined","e":"a b"}`,
+		},
+		{
+			name: "plain multiline",
+			yaml: "a: one\n  two\n\n  three\nb: x:y #c\n",
+			want: `{"a":"one two\nthree","b":"x:y"}`,
+		},
+		{
+			name: "block scalars",
+			yaml: `literal: |
+  line 1
+   indented
+
+  line 3
+folded: >
+  a
+  b
+
+  c
+   d
+  e
+strip: |-
+  x
+
+keep: |+
+  x
+
+clip: |
+  x
+
+
+indent: |2
+    two
+last: end
+`,
+			want: `{"literal":"line 1\n indented\n\nline 3\n","folded":"a b\nc\n d\ne\n","strip":"x","keep":"x\n\n","clip":"x\n","indent":"  two\n","last":"end"}`,
+		},
+		{
+			name: "anchors",
+			yaml: `base: &base
+  host: localhost
+  port: 80
+list: &list [1, 2]
+dev:
+  <<: *base
+  port: 8080
+both:
+  <<: [*base, {debug: true, host: other}]
+copy: *list
+null: &n
+again: *n
+`,
+			want: `{"base":{"host":"localhost","port":80},"list":[1,2],"dev":{"host":"localhost","port":8080},"both":{"host":"localhost","port":80,"debug":true},"copy":[1,2],"null":null,"again":null}`,
+		},
+		{
+			name: "tags",
+			yaml: "a: !!str 1\nb: !!int \"2\"\nc: !!str\nd: !!map {x: 1}\ne: ! true\n",
+			want: `{"a":"1","b":2,"c":"","d":{"x":1},"e":"true"}`,
+		},
+		{
+			name: "document",
+			yaml: "%YAML 1.2\n---\na: 1\n...\n",
+			want: `{"a":1}`,
+		},
+		{
+			name: "crlf",
+			yaml: "a: 1\r\nb:\r\n  - x\r\n",
+			want: `{"a":1,"b":["x"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := ParseYAML(&Source{Data: []byte(tt.yaml)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want interface{}
+			err = json.Unmarshal([]byte(tt.want), &want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.Interface(); !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseYAML() got = %s, want %s", n.Bytes(), tt.want)
+			}
+			var again interface{}
+			err = json.Unmarshal(n.Bytes(), &again)
+			if err != nil || !reflect.DeepEqual(again, want) {
+				t.Errorf("Bytes() got = %s, %v", n.Bytes(), err)
+			}
+		})
+	}
+}
+
+func TestParseYAMLError(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string
+	}{
+		{yaml: "a: 1\n b: 2", want: "2:3"},
+		{yaml: "a: b: c", want: "1:5"},
+		{yaml: "a: 1\na: 2", want: "2:1"},
+		{yaml: "a: *x", want: "1:4"},
+		{yaml: "a:\n\t- b", want: "2:1"},
+		{yaml: "a: \"b", want: "1:4"},
+		{yaml: "a: [1, 2", want: "1:9"},
+		{yaml: "a: !foo b", want: "1:9"},
+		{yaml: "? a\n: b", want: "1:1"},
+		{yaml: "- a\nb: c", want: "2:1"},
+		{yaml: "a: 1\n---\nb: 2", want: "2:1"},
+		{yaml: "a: 'x' y", want: "1:8"},
+		{yaml: "<<: 1", want: "1:5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.yaml, func(t *testing.T) {
+			_, err := ParseYAML(&Source{Name: "test.yaml", Data: []byte(tt.yaml)})
+			e, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("ParseYAML() error = %v, want a syntax error", err)
+			}
+			if got := strings.TrimPrefix(e.Pos.String(), "test.yaml:"); got != tt.want {
+				t.Errorf("ParseYAML() error at %s: %v, want at %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLAliasBudget(t *testing.T) {
+	laughs := `a: &a ["lol","lol","lol","lol","lol","lol","lol","lol","lol"]
+b: &b [*a,*a,*a,*a,*a,*a,*a,*a,*a]
+c: &c [*b,*b,*b,*b,*b,*b,*b,*b,*b]
+d: &d [*c,*c,*c,*c,*c,*c,*c,*c,*c]
+e: &e [*d,*d,*d,*d,*d,*d,*d,*d,*d]
+f: &f [*e,*e,*e,*e,*e,*e,*e,*e,*e]
+g: &g [*f,*f,*f,*f,*f,*f,*f,*f,*f]
+h: &h [*g,*g,*g,*g,*g,*g,*g,*g,*g]
+i: &i [*h,*h,*h,*h,*h,*h,*h,*h,*h]
+`
+	_, err := ParseYAML(&Source{Name: "test.yaml", Data: []byte(laughs)})
+	e, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("ParseYAML() error = %v, want a syntax error", err)
+	}
+	if got := strings.TrimPrefix(e.Pos.String(), "test.yaml:"); got != "4:17" {
+		t.Errorf("ParseYAML() error at %s: %v, want at 4:17", got, err)
+	}
+
+	_, err = ParseYAML(&Source{Name: "test.yaml", Data: []byte("a: &a [1, 2]\nb: [*a, *a, *a]\nc: *a\n")})
+	if err != nil {
+		t.Errorf("ParseYAML() error = %v", err)
+	}
+}
+
+func TestParseYAMLStream(t *testing.T) {
+	data := "a: 1\n---\n- b\n--- c\n---\n...\n# end\n"
+	docs, err := ParseYAMLStream(&Source{Data: []byte(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"a":1}`, `["b"]`, `"c"`, `null`}
+	if len(docs) != len(want) {
+		t.Fatalf("ParseYAMLStream() got %d documents, want %d", len(docs), len(want))
+	}
+	for i, doc := range docs {
+		if got := string(doc.Bytes()); got != want[i] {
+			t.Errorf("ParseYAMLStream() document %d got = %s, want %s", i, got, want[i])
+		}
+	}
+	if got := docs[1].Elems[0].Pos(); got.Line != 3 || got.Column != 3 {
+		t.Errorf("Pos() got = %v, want 3:3", got)
+	}
+}
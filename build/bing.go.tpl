@@ -17,7 +17,7 @@ import (
 
 var kindKey = `{{.Key}}`
 
-var provider = types.NewEmptyProvider()
+var provider = types.NewEmptyProvider(types.WithDiscriminator(kindKey){{if .ExactMatch}}, types.WithExactMatch(){{end}})
 
 // Unmarshal parses the encoded data and stores the result
 func Unmarshal(config []byte, v interface{}) error {
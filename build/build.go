@@ -8,16 +8,39 @@ import (
 )
 
 type Build struct {
-	pkg        string
-	typeOnce   map[string]struct{}
-	interfaces []interface{}
-	types      []interface{}
+	pkg           string
+	discriminator string
+	exactMatch    bool
+	typeOnce      map[string]struct{}
+	interfaces    []interface{}
+	types         []interface{}
 }
 
-func NewBuild(pkg string) *Build {
+// Option is an option of the Build.
+type Option func(b *Build)
+
+// WithDiscriminator sets the key of the kind in the generated code, "@Kind" by default.
+func WithDiscriminator(key string) Option {
+	return func(b *Build) {
+		b.discriminator = key
+	}
+}
+
+// WithExactMatch makes the generated provider match the key of the kind case-sensitively.
+func WithExactMatch() Option {
+	return func(b *Build) {
+		b.exactMatch = true
+	}
+}
+
+func NewBuild(pkg string, opts ...Option) *Build {
 	b := &Build{
-		pkg:      pkg,
-		typeOnce: map[string]struct{}{},
+		pkg:           pkg,
+		discriminator: "@Kind",
+		typeOnce:      map[string]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
 	return b
 }
@@ -28,7 +51,8 @@ func (b *Build) Bytes() []byte {
 		"Interfaces": b.interfaces,
 		"Types":      b.types,
 		"Package":    b.pkg,
-		"Key":        "@Kind",
+		"Key":        b.discriminator,
+		"ExactMatch": b.exactMatch,
 	})
 	if err != nil {
 		log.Printf("[ERROR] kind %s", err)
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"fmt"
+	"reflect"
+)
+
+func (h *provider) RegisterInverse(kind string, inverse interface{}) error {
+	if inverse == nil {
+		return nil
+	}
+	fun := reflect.ValueOf(inverse)
+	_, err := CheckFunc(fun)
+	if err != nil {
+		return fmt.Errorf("register inverse %s: %v: %w", kind, fun, err)
+	}
+	if fun.Type().NumIn() != 1 {
+		return fmt.Errorf("register inverse %s: %v: must take the component only", kind, fun)
+	}
+	h.inverses[fun.Type().In(0)] = kindInverse{kind: kind, fun: fun}
+	return nil
+}
+
+func (h *provider) Inverse(typ reflect.Type) (string, reflect.Value) {
+	if inv, ok := h.inverses[typ]; ok {
+		return inv.kind, inv.fun
+	}
+	if inv, ok := h.identities[typ]; ok {
+		return inv.kind, inv.fun
+	}
+	return "", reflect.Value{}
+}
+
+type kindInverse struct {
+	kind string
+	fun  reflect.Value
+}
+
+// registerIdentity makes the constructor that takes its own result as the config, such as func(c Config) (Config, error),
+// the inverse of its kind for the type.
+func (h *provider) registerIdentity(kind string, fun reflect.Value) {
+	typ := fun.Type()
+	if typ.NumIn() != 1 || typ.In(0) != typ.Out(0) || typ.Out(0).Kind() == reflect.Interface {
+		return
+	}
+	out := typ.Out(0)
+	h.identities[out] = kindInverse{
+		kind: kind,
+		fun: reflect.MakeFunc(reflect.FuncOf([]reflect.Type{out}, []reflect.Type{out}, false), func(args []reflect.Value) []reflect.Value {
+			return args
+		}),
+	}
+}
@@ -1,10 +1,12 @@
 package types
 
 import (
-	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
 )
 
 var (
@@ -16,21 +18,91 @@ var (
 
 var Default = NewEmptyProvider()
 
+// DefaultDiscriminator is the key of the kind when no other is given.
+const DefaultDiscriminator = "@kind"
+
 type Provider interface {
 	Register(kind string, fun interface{}) error
 	Find(kind string) (reflect.Value, bool)
 	Kind(config []byte) string
+	// Resolve returns the kind of the node and the config to build it from, the kind is empty if it is not a component.
+	Resolve(node *ast.Node) (kind string, config *ast.Node)
+	// Discriminator returns the key of the kind.
+	Discriminator() string
+	// IsDiscriminator reports whether the object key is the key of the kind.
+	IsDiscriminator(key string) bool
+	// RegisterShorthand registers the parser of the string form "kind:..." of the kind.
+	RegisterShorthand(kind string, parse ShorthandParser) error
+	// Shorthand returns the kind of the string form and its parser, the kind is empty if it is not a shorthand.
+	Shorthand(s string) (kind string, parse ShorthandParser)
+	// RegisterInverse registers the function turning the components of the kind back into their config,
+	// such as func(h *Handler) (HandlerConfig, error), the components are matched by the type of its parameter.
+	RegisterInverse(kind string, inverse interface{}) error
+	// Inverse returns the kind of the type and the function turning its values into their config,
+	// the kind is empty if there is none. Constructors taking their own result as the config are their own inverse.
+	Inverse(typ reflect.Type) (kind string, inverse reflect.Value)
 	ForEach(f func(kind string, fun reflect.Value))
 }
 
+// Option is an option of the provider.
+type Option func(p *provider)
+
+// WithDiscriminator sets the key of the kind, such as "type", "$type" or "kind".
+func WithDiscriminator(key string) Option {
+	return func(p *provider) {
+		p.discriminator = key
+	}
+}
+
+// WithExactMatch matches the key of the kind case-sensitively,
+// by default it is matched case-insensitively as encoding/json matches the fields.
+func WithExactMatch() Option {
+	return func(p *provider) {
+		p.exactMatch = true
+	}
+}
+
+// WithResolvers sets the strategies tried in order to resolve the kind of a node, InlineResolver by default.
+func WithResolvers(resolvers ...Resolver) Option {
+	return func(p *provider) {
+		p.resolvers = resolvers
+	}
+}
+
 type provider struct {
-	functions map[string]reflect.Value
+	functions     map[string]reflect.Value
+	shorthands    map[string]ShorthandParser
+	inverses      map[reflect.Type]kindInverse
+	identities    map[reflect.Type]kindInverse
+	discriminator string
+	exactMatch    bool
+	resolvers     []Resolver
+}
+
+func NewEmptyProvider(opts ...Option) Provider {
+	p := &provider{
+		functions:     map[string]reflect.Value{},
+		shorthands:    map[string]ShorthandParser{},
+		inverses:      map[reflect.Type]kindInverse{},
+		identities:    map[reflect.Type]kindInverse{},
+		discriminator: DefaultDiscriminator,
+		resolvers:     []Resolver{InlineResolver},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (h *provider) Discriminator() string {
+	return h.discriminator
 }
 
-func NewEmptyProvider() Provider {
-	return &provider{
-		functions: map[string]reflect.Value{},
+func (h *provider) IsDiscriminator(key string) bool {
+	if h.exactMatch {
+		return key == h.discriminator
 	}
+	return strings.EqualFold(key, h.discriminator)
 }
 
 func (h *provider) ForEach(f func(kind string, fun reflect.Value)) {
@@ -59,6 +131,7 @@ func (h *provider) register(kind string, fun reflect.Value) error {
 	}
 
 	h.functions[kind] = fun
+	h.registerIdentity(kind, fun)
 	return nil
 }
 
@@ -68,11 +141,62 @@ func (h *provider) Find(kind string) (reflect.Value, bool) {
 }
 
 func (h *provider) Kind(config []byte) string {
-	var k struct {
-		Kind string `json:"@kind"`
+	node, err := ast.Parse(&ast.Source{Data: config})
+	if err != nil {
+		return ""
+	}
+	kind, _ := h.Resolve(node)
+	return kind
+}
+
+func (h *provider) Resolve(node *ast.Node) (string, *ast.Node) {
+	for _, resolver := range h.resolvers {
+		kind, config := resolver(h, node)
+		if kind != "" {
+			return kind, config
+		}
+	}
+	return "", nil
+}
+
+// Resolver is a strategy to find the kind of a node and the config to build it from,
+// the kind is empty if the node is not a component in its style.
+type Resolver func(p Provider, node *ast.Node) (kind string, config *ast.Node)
+
+// InlineResolver resolves the kind from the discriminator key of the object, the object is the config,
+// such as {"@kind":"http","port":80}.
+func InlineResolver(p Provider, node *ast.Node) (string, *ast.Node) {
+	if node.Kind != ast.Object {
+		return "", nil
+	}
+	// The last key wins, as encoding/json does.
+	for i := len(node.Members) - 1; i >= 0; i-- {
+		m := node.Members[i]
+		if p.IsDiscriminator(m.Key) {
+			if m.Value.Kind != ast.String {
+				return "", nil
+			}
+			return m.Value.Value, node
+		}
+	}
+	return "", nil
+}
+
+// WrapperResolver resolves the kind from the single key of the object if it is a registered kind,
+// the object it holds is the config, such as {"http":{"port":80}}.
+// Maps with a single key named after a kind are resolved too, so use it where that cannot happen.
+func WrapperResolver(p Provider, node *ast.Node) (string, *ast.Node) {
+	if node.Kind != ast.Object || len(node.Members) != 1 {
+		return "", nil
+	}
+	m := node.Members[0]
+	if m.Value.Kind != ast.Object {
+		return "", nil
+	}
+	if _, ok := p.Find(m.Key); !ok {
+		return "", nil
 	}
-	json.Unmarshal(config, &k)
-	return k.Kind
+	return m.Key, m.Value
 }
 
 func CheckFunc(funcValue reflect.Value) (reflect.Type, error) {
@@ -2,6 +2,7 @@ package funcfg
 
 import (
 	"context"
+	"io"
 
 	"github.com/wzshiming/funcfg/types"
 	"github.com/wzshiming/funcfg/unmarshaler"
@@ -14,3 +15,52 @@ func Unmarshal(config []byte, v interface{}) error {
 	}
 	return u.Unmarshal(config, v)
 }
+
+func NewDecoder(r io.Reader) *unmarshaler.Decoder {
+	u := &unmarshaler.Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: types.Default,
+	}
+	return unmarshaler.NewDecoder(u, r)
+}
+
+// UnmarshalYAML decodes the YAML document into v as Unmarshal decodes JSON.
+func UnmarshalYAML(config []byte, v interface{}) error {
+	u := unmarshaler.Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: types.Default,
+	}
+	return u.UnmarshalYAML(config, v)
+}
+
+// UnmarshalTOML decodes the TOML document into v as Unmarshal decodes JSON.
+func UnmarshalTOML(config []byte, v interface{}) error {
+	u := unmarshaler.Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: types.Default,
+	}
+	return u.UnmarshalTOML(config, v)
+}
+
+// NewYAMLDecoder returns a decoder of the documents of the YAML stream in r.
+func NewYAMLDecoder(r io.Reader) *unmarshaler.YAMLDecoder {
+	u := &unmarshaler.Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: types.Default,
+	}
+	return unmarshaler.NewYAMLDecoder(u, r)
+}
+
+// Merge merges the JSON documents in order with RFC 7396 JSON Merge Patch,
+// an overlay with another kind replaces the object and arrays are replaced.
+func Merge(docs ...[]byte) ([]byte, error) {
+	return unmarshaler.Merge(unmarshaler.MergeOptions{Provider: types.Default}, docs...)
+}
+
+// Marshal returns the JSON config of v, the components are encoded with the kinds of the inverses registered in types.Default.
+func Marshal(v interface{}) ([]byte, error) {
+	m := unmarshaler.Marshaler{
+		Provider: types.Default,
+	}
+	return m.Marshal(v)
+}
@@ -0,0 +1,69 @@
+package unmarshaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/funcfg/types"
+)
+
+type benchNode struct {
+	Name  string
+	Next  Adapter
+	Items []Adapter
+}
+
+func (benchNode) M() {}
+
+func benchProvider(b *testing.B) types.Provider {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("node", func(conf struct {
+		Name  string
+		Next  Adapter
+		Items []Adapter
+	}) (Adapter, error) {
+		return benchNode{Name: conf.Name, Next: conf.Next, Items: conf.Items}, nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return provider
+}
+
+// deepConfig returns a chain of components nested depth times, each with a few siblings.
+func deepConfig(depth int) []byte {
+	buf := strings.Builder{}
+	for i := 0; i != depth; i++ {
+		fmt.Fprintf(&buf, `{"@kind":"node","name":"n%d","items":[{"@kind":"node","name":"a"},{"@kind":"node","name":"b"}],"next":`, i)
+	}
+	buf.WriteString(`{"@kind":"node","name":"leaf"}`)
+	buf.WriteString(strings.Repeat("}", depth))
+	return []byte(buf.String())
+}
+
+func benchmarkUnmarshalDeep(b *testing.B, depth int) {
+	config := deepConfig(depth)
+	u := Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: benchProvider(b),
+	}
+	b.SetBytes(int64(len(config)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var a Adapter
+		err := u.Unmarshal(config, &a)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalDeep10(b *testing.B) {
+	benchmarkUnmarshalDeep(b, 10)
+}
+
+func BenchmarkUnmarshalDeep100(b *testing.B) {
+	benchmarkUnmarshalDeep(b, 100)
+}
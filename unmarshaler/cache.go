@@ -0,0 +1,59 @@
+package unmarshaler
+
+import (
+	"reflect"
+	"sync"
+)
+
+// fieldCache is the fields of the structs decoded so far, map[reflect.Type]*structFields.
+var fieldCache sync.Map
+
+// cachedStructFields returns the fields of the struct, computed once per type.
+func cachedStructFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, newStructFields(t))
+	return f.(*structFields)
+}
+
+// paramCache is the parameters of the constructors called so far, map[reflect.Type][]reflect.Type.
+var paramCache sync.Map
+
+// cachedParams returns the parameters of the constructor type that are decoded from the config,
+// computed once per type.
+func cachedParams(funType reflect.Type) []reflect.Type {
+	if p, ok := paramCache.Load(funType); ok {
+		return p.([]reflect.Type)
+	}
+	p, _ := paramCache.LoadOrStore(funType, configParams(funType))
+	return p.([]reflect.Type)
+}
+
+// configParams returns the parameters of the constructor type that are decoded from the config,
+// the others are provided by the injector.
+func configParams(funType reflect.Type) []reflect.Type {
+	params := []reflect.Type{}
+	num := funType.NumIn()
+	for i := 0; i != num; i++ {
+		in := funType.In(i)
+		switch in.Kind() {
+		case reflect.Slice:
+			if in.Elem().Kind() == reflect.Uint8 {
+				continue
+			}
+		case reflect.Array, reflect.Struct, reflect.Map:
+		case reflect.Ptr:
+			if in.Elem().Kind() != reflect.Struct || in == astNodeType || in == unmarshalerType {
+				continue
+			}
+		default:
+			continue
+		}
+		params = append(params, in)
+	}
+	return params
+}
+
+// customCache is whether the pointer types have their own decoding, map[reflect.Type]bool.
+var customCache sync.Map
@@ -0,0 +1,276 @@
+package unmarshaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/funcfg/types"
+)
+
+type ConformanceEmbedded struct {
+	Embedded string
+	Shadowed string
+}
+
+type conformanceUnexported struct {
+	Promoted string
+}
+
+type ConformanceTags struct {
+	Renamed   string  `json:"renamed"`
+	Skipped   string  `json:"-"`
+	Dash      string  `json:"-,"`
+	OmitEmpty string  `json:",omitempty"`
+	Int       int     `json:",string"`
+	IntPtr    *int    `json:",string"`
+	Uint      uint8   `json:",string"`
+	Float     float64 `json:",string"`
+	Bool      bool    `json:",string"`
+	String    string  `json:",string"`
+	Slice     []int   `json:",string"`
+	unexposed string
+}
+
+type ConformanceEmbedding struct {
+	ConformanceEmbedded
+	conformanceUnexported
+	Shadowed string
+}
+
+type ConformanceConflict struct {
+	A1
+	A2
+	B1
+	B2 `json:"B"`
+}
+
+type ConformanceKey struct {
+	Prefix string
+	Name   string
+}
+
+func (k *ConformanceKey) UnmarshalText(text []byte) error {
+	s := string(text)
+	i := strings.Index(s, ".")
+	if i < 0 {
+		return fmt.Errorf("invalid key %q", s)
+	}
+	k.Prefix, k.Name = s[:i], s[i+1:]
+	return nil
+}
+
+// ConformanceTextKey is a string type with UnmarshalText that takes precedence over the string kind.
+type ConformanceTextKey string
+
+func (k *ConformanceTextKey) UnmarshalText(text []byte) error {
+	*k = ConformanceTextKey(strings.ToUpper(string(text)))
+	return nil
+}
+
+type A1 struct{ A int }
+type A2 struct{ A int }
+type B1 struct{ B int }
+type B2 struct{ B int }
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		new    func() interface{}
+	}{
+		{
+			name:   "renamed",
+			config: `{"renamed":"a","Renamed":"b"}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "case insensitive",
+			config: `{"RENAMED":"a","omitempty":"b"}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "skipped",
+			config: `{"Skipped":"a","-":"b","unexposed":"c"}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "string option",
+			config: `{"Int":"-1","IntPtr":"2","Uint":"3","Float":"1.5","Bool":"true","String":"\"s\""}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "string option null",
+			config: `{"Int":null,"IntPtr":"null","Bool":"null"}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "string option ignored",
+			config: `{"Slice":[1,2]}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "string option unquoted",
+			config: `{"Int":1}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "string option invalid",
+			config: `{"Bool":"1"}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "string option string unquoted",
+			config: `{"String":"s"}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "string option overflow",
+			config: `{"Uint":"256"}`,
+			new:    func() interface{} { return &ConformanceTags{} },
+		},
+		{
+			name:   "embedded",
+			config: `{"Embedded":"a","Shadowed":"b","Promoted":"c"}`,
+			new:    func() interface{} { return &ConformanceEmbedding{} },
+		},
+		{
+			name:   "conflict",
+			config: `{"A":1,"B":2}`,
+			new:    func() interface{} { return &ConformanceConflict{} },
+		},
+		{
+			name:   "null",
+			config: `{"A":null,"B":null,"C":null,"D":null}`,
+			new: func() interface{} {
+				return &struct {
+					A *int
+					B []int
+					C map[string]int
+					D int
+				}{}
+			},
+		},
+		{
+			name:   "null interface",
+			config: `null`,
+			new:    func() interface{} { v := interface{}(1); return &v },
+		},
+		{
+			name:   "null interface map value",
+			config: `{"a":null}`,
+			new:    func() interface{} { return &map[string]interface{}{} },
+		},
+		{
+			name:   "null interface field",
+			config: `{"Any":null}`,
+			new:    func() interface{} { return &struct{ Any interface{} }{Any: 1} },
+		},
+		{
+			name:   "null interface element",
+			config: `[null,1]`,
+			new:    func() interface{} { return &[]interface{}{} },
+		},
+		{
+			name:   "array",
+			config: `{"A":[1,2,3],"B":[1]}`,
+			new: func() interface{} {
+				return &struct {
+					A [2]int
+					B [2]int
+				}{}
+			},
+		},
+		{
+			name:   "nested",
+			config: `{"A":{"B":[{"C":{"d":1}}]},"E":[[1],[]],"F":{"x":"y"}}`,
+			new: func() interface{} {
+				return &struct {
+					A *struct{ B []struct{ C map[string]int } }
+					E [][]int
+					F interface{}
+				}{}
+			},
+		},
+		{
+			name:   "type mismatch",
+			config: `{"A":"1"}`,
+			new:    func() interface{} { return &struct{ A int }{} },
+		},
+		{
+			name:   "map int key",
+			config: `{"1":"a","-2":"b"}`,
+			new:    func() interface{} { return &map[int8]string{} },
+		},
+		{
+			name:   "map int key overflow",
+			config: `{"128":"a"}`,
+			new:    func() interface{} { return &map[int8]string{} },
+		},
+		{
+			name:   "map uint key",
+			config: `{"1":"a","255":"b"}`,
+			new:    func() interface{} { return &map[uint8]string{} },
+		},
+		{
+			name:   "map uint key negative",
+			config: `{"-1":"a"}`,
+			new:    func() interface{} { return &map[uint]string{} },
+		},
+		{
+			name:   "map named string key",
+			config: `{"a":1}`,
+			new:    func() interface{} { return &map[ConfigName]int{} },
+		},
+		{
+			name:   "map text key",
+			config: `{"a.b":1,"c.d":2}`,
+			new:    func() interface{} { return &map[ConformanceKey]int{} },
+		},
+		{
+			name:   "map text key invalid",
+			config: `{"a":1}`,
+			new:    func() interface{} { return &map[ConformanceKey]int{} },
+		},
+		{
+			name:   "map text string key",
+			config: `{"a":1}`,
+			new:    func() interface{} { return &map[ConformanceTextKey]int{} },
+		},
+		{
+			name:   "map unsupported key",
+			config: `{"1":1}`,
+			new:    func() interface{} { return &map[[2]int]int{} },
+		},
+		{
+			name:   "top level",
+			config: `[1,2]`,
+			new:    func() interface{} { return &[]int{} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.new()
+			wantErr := json.Unmarshal([]byte(tt.config), want)
+
+			got := tt.new()
+			u := Unmarshaler{
+				Ctx:      context.Background(),
+				Provider: types.NewEmptyProvider(),
+			}
+			err := u.Unmarshal([]byte(tt.config), got)
+			if (err != nil) != (wantErr != nil) {
+				t.Fatalf("Unmarshal() error = %v, encoding/json error = %v", err, wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Unmarshal() got = %#v, encoding/json got %#v", got, want)
+			}
+		})
+	}
+}
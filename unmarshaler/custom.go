@@ -0,0 +1,70 @@
+package unmarshaler
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// FuncfgUnmarshaler is implemented by types that decode themselves with the Unmarshaler,
+// so that the interface typed values inside them are still decoded by kind.
+// Calling Unmarshal of u with the config decodes it in place, keeping the path and positions of errors.
+type FuncfgUnmarshaler interface {
+	UnmarshalFuncfg(u *Unmarshaler, config []byte) error
+}
+
+var (
+	funcfgUnmarshalerType = reflect.TypeOf((*FuncfgUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType   = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isCustom reports whether the type or the pointer to it has its own decoding.
+func isCustom(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Ptr {
+		typ = reflect.PtrTo(typ)
+	}
+	if c, ok := customCache.Load(typ); ok {
+		return c.(bool)
+	}
+	c := typ.Implements(funcfgUnmarshalerType) ||
+		typ.Implements(jsonUnmarshalerType) ||
+		typ.Implements(textUnmarshalerType)
+	customCache.Store(typ, c)
+	return c
+}
+
+// decodeCustom delegates to the first of UnmarshalFuncfg, UnmarshalJSON and UnmarshalText
+// found through the pointers v points to, allocating them as encoding/json does.
+// It reports false if the target has no custom decoding.
+func (d *Unmarshaler) decodeCustom(path string, n *ast.Node, v reflect.Value) (bool, error) {
+	for {
+		if isCustom(v.Type()) && v.CanInterface() {
+			switch u := v.Interface().(type) {
+			case FuncfgUnmarshaler:
+				sub := *d
+				sub.node = n
+				sub.path = path
+				sub.config = n.Bytes()
+				return true, u.UnmarshalFuncfg(&sub, sub.config)
+			case json.Unmarshaler, encoding.TextUnmarshaler:
+				// encoding/json takes care of null and of the node kinds
+				// the TextUnmarshaler accepts.
+				return true, json.Unmarshal(n.Bytes(), u)
+			}
+		}
+		e := v.Elem()
+		if e.Kind() != reflect.Ptr || n.Kind == ast.Null {
+			return false, nil
+		}
+		if e.IsNil() {
+			if !isCustom(e.Type()) {
+				return false, nil
+			}
+			e.Set(reflect.New(e.Type().Elem()))
+		}
+		v = e
+	}
+}
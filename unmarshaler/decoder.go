@@ -0,0 +1,93 @@
+package unmarshaler
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// Decoder reads and decodes a stream of concatenated or newline-delimited documents.
+type Decoder struct {
+	u   *Unmarshaler
+	r   *lineCounter
+	dec *json.Decoder
+}
+
+// NewDecoder returns a new decoder that reads from r and decodes with u.
+func NewDecoder(u *Unmarshaler, r io.Reader) *Decoder {
+	lc := &lineCounter{r: r, line: 1}
+	return &Decoder{
+		u:   u,
+		r:   lc,
+		dec: json.NewDecoder(lc),
+	}
+}
+
+// More reports whether there is another document in the input.
+func (d *Decoder) More() bool {
+	return d.dec.More()
+}
+
+// Decode reads the next document from its input and stores it in the value pointed to by v,
+// it returns io.EOF at the end of the input.
+func (d *Decoder) Decode(v interface{}) error {
+	var raw json.RawMessage
+	err := d.dec.Decode(&raw)
+	if err != nil {
+		if se, ok := err.(*json.SyntaxError); ok && se.Offset > 0 {
+			offset := se.Offset - 1
+			e := &DecodeError{Offset: int(offset), Filename: d.u.Filename, Err: err}
+			e.Line, e.Column = d.r.position(offset)
+			return e
+		}
+		return err
+	}
+	offset := d.dec.InputOffset() - int64(len(raw))
+	line, column := d.r.position(offset)
+	src := &ast.Source{
+		Name:   d.u.Filename,
+		Data:   raw,
+		Offset: int(offset),
+		Line:   line,
+		Column: column,
+	}
+	return d.u.unmarshalSource(src, v)
+}
+
+// lineCounter records the newlines read, to locate the documents within the stream.
+type lineCounter struct {
+	r io.Reader
+	n int64
+
+	// newlines is the offsets of the newlines not yet passed by position.
+	newlines []int64
+	// line is the line number starting at lineStart.
+	line      int
+	lineStart int64
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.newlines = append(c.newlines, c.n+int64(i))
+		}
+	}
+	c.n += int64(n)
+	return n, err
+}
+
+// position returns the line and column of the offset, offsets must not go backwards.
+func (c *lineCounter) position(offset int64) (line, column int) {
+	i := 0
+	for i < len(c.newlines) && c.newlines[i] < offset {
+		i++
+	}
+	if i != 0 {
+		c.line += i
+		c.lineStart = c.newlines[i-1] + 1
+		c.newlines = c.newlines[i:]
+	}
+	return c.line, int(offset-c.lineStart) + 1
+}
@@ -0,0 +1,165 @@
+package unmarshaler
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// DecodeError describes a failure to decode the value at Path.
+type DecodeError struct {
+	// Path is the JSON Pointer (RFC 6901) of the value that failed, "" is the root.
+	Path string
+	// Kind is the kind being built, empty if the value is not a component.
+	Kind string
+	// Type is the Go type being decoded into.
+	Type reflect.Type
+	// Offset is the byte offset of the value in the input.
+	Offset int
+	// Filename, Line and Column are the position of the value in the input, Line is 0 if unknown.
+	Filename string
+	Line     int
+	Column   int
+	// Err is the underlying cause.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	buf := strings.Builder{}
+	if e.Line > 0 {
+		if e.Filename != "" {
+			buf.WriteString(e.Filename)
+			buf.WriteString(":")
+		}
+		fmt.Fprintf(&buf, "%d:%d: ", e.Line, e.Column)
+	}
+	fmt.Fprintf(&buf, "decode %q", e.Path)
+	if e.Kind != "" {
+		fmt.Fprintf(&buf, " kind %q", e.Kind)
+	}
+	if e.Type != nil {
+		fmt.Fprintf(&buf, " into %s", e.Type)
+	}
+	buf.WriteString(": ")
+	buf.WriteString(e.Err.Error())
+	return buf.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeErrors is the list of errors returned when Unmarshaler.AllErrors is set.
+type DecodeErrors []*DecodeError
+
+func (e DecodeErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e DecodeErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Is reports whether any of the errors matches target,
+// errors.Is follows Unwrap() []error only since Go 1.20.
+func (e DecodeErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target and sets target to it.
+func (e DecodeErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e DecodeErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// appendError appends the errors located by wrapError to errs.
+func appendError(errs DecodeErrors, err error) DecodeErrors {
+	switch e := err.(type) {
+	case DecodeErrors:
+		return append(errs, e...)
+	case *DecodeError:
+		return append(errs, e)
+	default:
+		return append(errs, &DecodeError{Err: err})
+	}
+}
+
+// newDecodeError returns the error located at the node.
+func newDecodeError(path string, n *ast.Node, kind string, typ reflect.Type, err error) *DecodeError {
+	e := &DecodeError{
+		Path: path,
+		Kind: kind,
+		Type: typ,
+		Err:  err,
+	}
+	if n != nil {
+		e.setPosition(n.Pos())
+	}
+	return e
+}
+
+func (e *DecodeError) setPosition(pos ast.Position) {
+	e.Filename = pos.Filename
+	e.Offset = pos.Offset
+	e.Line = pos.Line
+	e.Column = pos.Column
+}
+
+// wrapError records the location of err, errors already located deeper in the tree are kept as is.
+func wrapError(path string, n *ast.Node, kind string, value reflect.Value, err error) error {
+	switch err.(type) {
+	case *DecodeError, DecodeErrors:
+		return err
+	}
+	var typ reflect.Type
+	if value.IsValid() {
+		typ = value.Type()
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+	return newDecodeError(path, n, kind, typ, err)
+}
+
+// syntaxError locates the error of parsing the input.
+func syntaxError(err error) error {
+	e := &DecodeError{Err: err}
+	if se, ok := err.(*ast.SyntaxError); ok {
+		e.setPosition(se.Pos)
+	}
+	return e
+}
+
+var pathEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// appendPath appends the reference token to the JSON Pointer path.
+func appendPath(path string, token string) string {
+	return path + "/" + pathEscaper.Replace(token)
+}
@@ -0,0 +1,313 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// field is a field of a struct to decode into, possibly promoted from embedded structs.
+type field struct {
+	name      string
+	tagged    bool
+	index     []int
+	typ       reflect.Type
+	quoted    bool
+	omitEmpty bool
+
+	// def is the value of the default tag, applied if the key is absent.
+	def    string
+	hasDef bool
+
+	// rules is the parsed validate tag.
+	rules []rule
+}
+
+// typeFields returns the fields of the struct type in the order of the index,
+// following the visibility and conflict rules of Go for embedded structs the same as encoding/json.
+func typeFields(t reflect.Type) []field {
+	current := []field{}
+	next := []field{{typ: t}}
+
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+
+	visited := map[reflect.Type]bool{}
+
+	var fields []field
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			num := f.typ.NumField()
+			for i := 0; i != num; i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					t := sf.Type
+					if t.Kind() == reflect.Ptr {
+						t = t.Elem()
+					}
+					if sf.PkgPath != "" && t.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+				if !isValidTag(name) {
+					name = ""
+				}
+
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				// Only strings, floats, integers, and booleans can be quoted.
+				quoted := false
+				if opts.contains("string") {
+					switch ft.Kind() {
+					case reflect.Bool,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64,
+						reflect.String:
+						quoted = true
+					}
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					def, hasDef := sf.Tag.Lookup("default")
+					fields = append(fields, field{
+						name:      name,
+						tagged:    tagged,
+						index:     index,
+						typ:       sf.Type,
+						quoted:    quoted,
+						omitEmpty: opts.contains("omitempty"),
+						def:       def,
+						hasDef:    hasDef,
+						rules:     parseRules(sf.Tag.Get("validate")),
+					})
+					if count[f.typ] > 1 {
+						// If there were multiple instances, add a second,
+						// so that the annihilation code will see a duplicate.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, field{name: ft.Name(), index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		x := fields
+		if x[i].name != x[j].name {
+			return x[i].name < x[j].name
+		}
+		if len(x[i].index) != len(x[j].index) {
+			return len(x[i].index) < len(x[j].index)
+		}
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+		return indexLess(x[i].index, x[j].index)
+	})
+
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		fi := fields[i]
+		name := fi.name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fi)
+			continue
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+
+	fields = out
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].index, fields[j].index)
+	})
+	return fields
+}
+
+// structFields is the fields of a struct with the lookup by key.
+type structFields struct {
+	list        []field
+	byExactName map[string]int
+	byFoldName  map[string]int
+}
+
+func newStructFields(t reflect.Type) *structFields {
+	list := typeFields(t)
+	f := &structFields{
+		list:        list,
+		byExactName: make(map[string]int, len(list)),
+		byFoldName:  make(map[string]int, len(list)),
+	}
+	for i, field := range list {
+		if _, ok := f.byExactName[field.name]; !ok {
+			f.byExactName[field.name] = i
+		}
+		fold := strings.ToLower(field.name)
+		if _, ok := f.byFoldName[fold]; !ok {
+			f.byFoldName[fold] = i
+		}
+	}
+	return f
+}
+
+// lookup returns the index of the field matching the key, the exact name is preferred over the case-insensitive match.
+func (f *structFields) lookup(key string) (int, bool) {
+	i, ok := f.byExactName[key]
+	if !ok {
+		i, ok = f.byFoldName[strings.ToLower(key)]
+	}
+	return i, ok
+}
+
+// dominantField returns the field that hides the others with the same name,
+// fields are sorted by depth and tagged first.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for k, x := range a {
+		if k >= len(b) {
+			return false
+		}
+		if x != b[k] {
+			return x < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// fieldByIndex returns the field of v, allocating the embedded pointers on the way.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct: %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+type tagOptions string
+
+// parseTag splits a struct field's json tag into its name and comma-separated options.
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+// contains reports whether a comma-separated list of options contains a particular option.
+func (o tagOptions) contains(option string) bool {
+	s := string(o)
+	for s != "" {
+		var name string
+		if i := strings.Index(s, ","); i >= 0 {
+			name, s = s[:i], s[i+1:]
+		} else {
+			name, s = s, ""
+		}
+		if name == option {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+			// Backslash and quote chars are reserved, but
+			// otherwise any punctuation chars are allowed
+			// in a tag name.
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// defaultNode returns the node of the default tag for the type,
+// durations and strings may be written bare, everything else is JSON.
+func defaultNode(def string, typ reflect.Type) *ast.Node {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	src := &ast.Source{Name: "default", Data: []byte(def)}
+	switch {
+	case typ == durationType:
+		d, err := time.ParseDuration(def)
+		if err == nil {
+			return &ast.Node{Kind: ast.Number, Source: src, Value: strconv.FormatInt(int64(d), 10)}
+		}
+	case typ.Kind() == reflect.String:
+		return &ast.Node{Kind: ast.String, Source: src, Value: def}
+	}
+	n, err := ast.Parse(src)
+	if err != nil {
+		return &ast.Node{Kind: ast.String, Source: src, Value: def}
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package unmarshaler
+
+import (
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// UnmarshalYAML decodes the YAML document as Unmarshal decodes JSON, the errors are located in the YAML.
+func (d *Unmarshaler) UnmarshalYAML(config []byte, i interface{}) error {
+	n, err := ast.ParseYAML(&ast.Source{Name: d.Filename, Data: config})
+	if err != nil {
+		return syntaxError(err)
+	}
+	return d.UnmarshalNode(n, i)
+}
+
+// UnmarshalTOML decodes the TOML document as Unmarshal decodes JSON, the tables are objects
+// and the errors are located in the TOML.
+func (d *Unmarshaler) UnmarshalTOML(config []byte, i interface{}) error {
+	n, err := ast.ParseTOML(&ast.Source{Name: d.Filename, Data: config})
+	if err != nil {
+		return syntaxError(err)
+	}
+	return d.UnmarshalNode(n, i)
+}
+
+// YAMLDecoder reads and decodes the documents of a YAML stream separated by "---".
+type YAMLDecoder struct {
+	u      *Unmarshaler
+	r      io.Reader
+	stream *ast.YAMLStream
+}
+
+// NewYAMLDecoder returns a new decoder that reads from r and decodes with u,
+// the input is read in full on the first call of Decode.
+func NewYAMLDecoder(u *Unmarshaler, r io.Reader) *YAMLDecoder {
+	return &YAMLDecoder{
+		u: u,
+		r: r,
+	}
+}
+
+// Decode decodes the next document of its input into the value pointed to by v,
+// it returns io.EOF at the end of the input.
+func (d *YAMLDecoder) Decode(v interface{}) error {
+	if d.stream == nil {
+		data, err := ioutil.ReadAll(d.r)
+		if err != nil {
+			return err
+		}
+		d.stream = ast.NewYAMLStream(&ast.Source{Name: d.u.Filename, Data: data})
+	}
+	n, err := d.stream.Next()
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return syntaxError(err)
+	}
+	return d.u.UnmarshalNode(n, v)
+}
+
+// parseFile parses the file as YAML if its extension is .yaml or .yml, as TOML if it is .toml, as JSON otherwise.
+func parseFile(src *ast.Source) (*ast.Node, error) {
+	switch strings.ToLower(path.Ext(src.Name)) {
+	case ".yaml", ".yml":
+		return ast.ParseYAML(src)
+	case ".toml":
+		return ast.ParseTOML(src)
+	}
+	return ast.Parse(src)
+}
@@ -0,0 +1,132 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// includeKey replaces the object with the content of the file, or with an array of the files matching the glob,
+// such as {"@include":"teams/*.json"}.
+const includeKey = "@include"
+
+// includes resolves the @include objects of a decode.
+type includes struct {
+	d *Unmarshaler
+	// files is the resolved content of the files included so far.
+	files map[string]*ast.Node
+	// stack is the files being included, to detect cycles.
+	stack []string
+}
+
+func isIncludeKey(key string) bool {
+	return strings.EqualFold(key, includeKey)
+}
+
+// resolveIncludes returns the tree with the @include objects replaced by the content of the files from FS,
+// the paths are relative to the file holding them.
+func (d *Unmarshaler) resolveIncludes(path string, n *ast.Node) (*ast.Node, error) {
+	if d.FS == nil || !hasKey(n, isIncludeKey) {
+		return n, nil
+	}
+	inc := &includes{
+		d:     d,
+		files: map[string]*ast.Node{},
+	}
+	if n.Source != nil && n.Source.Name != "" {
+		inc.stack = append(inc.stack, cleanPath(n.Source.Name))
+	}
+	return inc.resolve(path, n)
+}
+
+func (inc *includes) resolve(p string, n *ast.Node) (*ast.Node, error) {
+	if m, ok := lookupFold(n, includeKey); ok {
+		// The other keys beside @include are ignored.
+		return inc.include(p, n, m)
+	}
+	return rewriteChildren(p, n, inc.resolve)
+}
+
+// include returns the content of the files the @include member of the object n names, p is the path of n.
+func (inc *includes) include(p string, n *ast.Node, m *ast.Member) (*ast.Node, error) {
+	value := m.Value
+	errPath := appendPath(p, m.Key)
+	if value.Kind != ast.String {
+		return nil, newDecodeError(errPath, value, "", nil, fmt.Errorf("%w: %s must be a string", ErrInclude, includeKey))
+	}
+	name := value.Value
+	if n.Source != nil && n.Source.Name != "" && !strings.HasPrefix(name, "/") {
+		name = path.Join(path.Dir(n.Source.Name), name)
+	}
+	name = cleanPath(name)
+	if !fs.ValidPath(name) {
+		return nil, newDecodeError(errPath, value, "", nil, fmt.Errorf("%w: invalid path %q", ErrInclude, value.Value))
+	}
+
+	if !strings.ContainsAny(name, "*?[") {
+		return inc.file(p, errPath, value, name)
+	}
+	matches, err := fs.Glob(inc.d.FS, name)
+	if err != nil {
+		return nil, newDecodeError(errPath, value, "", nil, fmt.Errorf("%w: %v", ErrInclude, err))
+	}
+	arr := &ast.Node{
+		Kind:   ast.Array,
+		Source: value.Source,
+		Offset: value.Offset,
+		Elems:  make([]*ast.Node, 0, len(matches)),
+	}
+	for _, match := range matches {
+		elem, err := inc.file(appendPath(p, strconv.Itoa(len(arr.Elems))), errPath, value, match)
+		if err != nil {
+			return nil, err
+		}
+		arr.Elems = append(arr.Elems, elem)
+	}
+	return arr, nil
+}
+
+// file returns the content of the file at the path p with the includes in it resolved,
+// value is the include naming it at errPath.
+func (inc *includes) file(p string, errPath string, value *ast.Node, name string) (*ast.Node, error) {
+	if n, ok := inc.files[name]; ok {
+		return n, nil
+	}
+	for i, s := range inc.stack {
+		if s == name {
+			cycle := append(append([]string{}, inc.stack[i:]...), name)
+			return nil, newDecodeError(errPath, value, "", nil, fmt.Errorf("%w: cycle %s", ErrInclude, strings.Join(cycle, " -> ")))
+		}
+	}
+	data, err := fs.ReadFile(inc.d.FS, name)
+	if err != nil {
+		return nil, newDecodeError(errPath, value, "", nil, fmt.Errorf("%w: %v", ErrInclude, err))
+	}
+	n, err := parseFile(&ast.Source{Name: name, Data: data})
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+
+	inc.stack = append(inc.stack, name)
+	n, err = inc.resolve(p, n)
+	inc.stack = inc.stack[:len(inc.stack)-1]
+	if err != nil {
+		return nil, err
+	}
+	// The $ref of the file point within it.
+	n, err = inc.d.resolvePointers(p, n)
+	if err != nil {
+		return nil, err
+	}
+	inc.files[name] = n
+	return n, nil
+}
+
+// cleanPath returns the path in the form of fs.FS, without the leading slash.
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
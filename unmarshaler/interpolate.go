@@ -0,0 +1,176 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// MapLookup returns the lookup of the variables of Interpolate in the map, instead of the environment.
+func MapLookup(m map[string]string) func(name string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := m[name]
+		return v, ok
+	}
+}
+
+// interpolate returns the tree with the expressions in the strings expanded,
+// the tree is copied only where it changes.
+func (d *Unmarshaler) interpolate(path string, n *ast.Node) (*ast.Node, error) {
+	lookup := d.Lookup
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	var errs DecodeErrors
+	n, err := d.interpolateNode(path, n, lookup, map[*ast.Node]*ast.Node{}, &errs)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+	return n, nil
+}
+
+func (d *Unmarshaler) interpolateNode(path string, n *ast.Node, lookup func(string) (string, bool), done map[*ast.Node]*ast.Node, errs *DecodeErrors) (*ast.Node, error) {
+	if r, ok := done[n]; ok {
+		return r, nil
+	}
+	r := n
+	switch n.Kind {
+	case ast.String:
+		if !strings.Contains(n.Value, "$") {
+			break
+		}
+		s, single, err := expand(n.Value, lookup)
+		if err != nil {
+			e := newDecodeError(path, n, "", nil, err)
+			if !d.AllErrors {
+				return nil, e
+			}
+			*errs = append(*errs, e)
+			break
+		}
+		if s != n.Value || single {
+			c := *n
+			c.Raw = nil
+			c.Value = s
+			c.Interpolated = single
+			r = &c
+		}
+	case ast.Object, ast.Array:
+		var err error
+		r, err = rewriteChildren(path, n, func(path string, n *ast.Node) (*ast.Node, error) {
+			return d.interpolateNode(path, n, lookup, done, errs)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	done[n] = r
+	return r, nil
+}
+
+// expand replaces ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?message} and ${VAR?message} in s,
+// "$$" is a literal "$" and so is a "$" before any other character.
+// The forms with the colon also apply when the variable is empty, defaults are not expanded again.
+// single reports whether s is a single expression.
+func expand(s string, lookup func(string) (string, bool)) (string, bool, error) {
+	buf := strings.Builder{}
+	exprs := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '$' || i+1 == len(s) {
+			buf.WriteByte(c)
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			buf.WriteByte('$')
+			i++
+			continue
+		case '{':
+		default:
+			buf.WriteByte(c)
+			continue
+		}
+		end := strings.IndexByte(s[i+2:], '}')
+		if end < 0 {
+			return "", false, fmt.Errorf("%w: unterminated %q", ErrInterpolation, s[i:])
+		}
+		v, err := expandExpr(s[i+2:i+2+end], lookup)
+		if err != nil {
+			return "", false, err
+		}
+		buf.WriteString(v)
+		exprs++
+		i += 2 + end
+	}
+	out := buf.String()
+	single := exprs == 1 && strings.HasPrefix(s, "${") && strings.IndexByte(s, '}') == len(s)-1
+	return out, single, nil
+}
+
+func expandExpr(expr string, lookup func(string) (string, bool)) (string, error) {
+	i := 0
+	for i < len(expr) && isNameByte(expr[i], i == 0) {
+		i++
+	}
+	name, op := expr[:i], expr[i:]
+	if name == "" {
+		return "", fmt.Errorf("%w: invalid expression ${%s}", ErrInterpolation, expr)
+	}
+	v, ok := lookup(name)
+	colon := strings.HasPrefix(op, ":")
+	if colon {
+		op = op[1:]
+	}
+	unset := !ok || colon && v == ""
+	switch {
+	case op == "" && !colon:
+		return v, nil
+	case strings.HasPrefix(op, "-"):
+		if unset {
+			return op[1:], nil
+		}
+		return v, nil
+	case strings.HasPrefix(op, "?"):
+		if unset {
+			msg := op[1:]
+			if msg == "" {
+				msg = "not set"
+			}
+			return "", fmt.Errorf("%w: %s: %s", ErrInterpolation, name, msg)
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("%w: invalid expression ${%s}", ErrInterpolation, expr)
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// interpolatedScalar returns the number or bool the interpolated string holds if the type is one of them.
+func interpolatedScalar(n *ast.Node, typ reflect.Type) *ast.Node {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+	default:
+		return n
+	}
+	item, err := ast.Parse(&ast.Source{Data: []byte(n.Value)})
+	if err != nil || item.Kind != ast.Number && item.Kind != ast.Bool {
+		return n
+	}
+	relocate(item, n)
+	return item
+}
@@ -0,0 +1,381 @@
+package unmarshaler
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/wzshiming/funcfg/ast"
+	"github.com/wzshiming/funcfg/types"
+)
+
+// FuncfgMarshaler is implemented by types that encode themselves with the Marshaler,
+// so that the interface typed values inside them are still encoded with their kinds.
+type FuncfgMarshaler interface {
+	MarshalFuncfg(m *Marshaler) ([]byte, error)
+}
+
+var (
+	funcfgMarshalerType = reflect.TypeOf((*FuncfgMarshaler)(nil)).Elem()
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Marshaler encodes the values back into the config they are decoded from.
+type Marshaler struct {
+	Provider types.Provider
+}
+
+// Marshal returns the JSON encoding of v as encoding/json does, except that the components held by interface typed values,
+// v included, are encoded as the config returned by the inverse of their kind with the discriminator of the Provider,
+// or wrapped in an object with the kind as the key if the Provider resolves only that style.
+// A value of an interface type with methods must have a kind or its own encoding.
+func (m *Marshaler) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := m.encodeComponent(&buf, "", reflect.ValueOf(v), false)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Marshaler) encode(buf *bytes.Buffer, path string, v reflect.Value) error {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return m.encodeComponent(buf, path, v.Elem(), v.NumMethod() != 0)
+	}
+	ok, err := m.encodeCustom(buf, path, v)
+	if ok {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return m.encode(buf, path, v.Elem())
+	case reflect.Struct:
+		return m.encodeStruct(buf, path, v)
+	case reflect.Map:
+		return m.encodeMap(buf, path, v)
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && !isMarshaler(v.Type().Elem()) {
+			b, err := json.Marshal(v.Bytes())
+			if err != nil {
+				return fmt.Errorf("marshal %q: %w", path, err)
+			}
+			buf.Write(b)
+			return nil
+		}
+		return m.encodeArray(buf, path, v)
+	case reflect.Array:
+		return m.encodeArray(buf, path, v)
+	}
+	return encodeScalar(buf, path, v)
+}
+
+// encodeComponent encodes the value held by an interface typed value, with its kind if it has one.
+func (m *Marshaler) encodeComponent(buf *bytes.Buffer, path string, v reflect.Value, needKind bool) error {
+	if !v.IsValid() {
+		buf.WriteString("null")
+		return nil
+	}
+	if m.Provider != nil {
+		kind, inverse := m.Provider.Inverse(v.Type())
+		if kind == "" && v.Kind() == reflect.Ptr && !v.IsNil() {
+			if k, inv := m.Provider.Inverse(v.Type().Elem()); k != "" {
+				kind, inverse, v = k, inv, v.Elem()
+			}
+		}
+		if kind != "" {
+			return m.encodeKind(buf, path, kind, inverse, v)
+		}
+	}
+	if needKind && !isMarshaler(v.Type()) {
+		return fmt.Errorf("marshal %q: %w: %s has no kind", path, ErrMarshal, v.Type())
+	}
+	return m.encode(buf, path, v)
+}
+
+// encodeKind encodes the config of the component with the discriminator as the first key, or wrapped by the kind.
+func (m *Marshaler) encodeKind(buf *bytes.Buffer, path string, kind string, inverse reflect.Value, v reflect.Value) error {
+	out := inverse.Call([]reflect.Value{v})
+	if len(out) == 2 && !out[1].IsNil() {
+		return fmt.Errorf("marshal %q kind %q: %w", path, kind, out[1].Interface().(error))
+	}
+	config := bytes.Buffer{}
+	err := m.encode(&config, path, out[0])
+	if err != nil {
+		return err
+	}
+	b := config.Bytes()
+	if len(b) == 0 || b[0] != '{' {
+		return fmt.Errorf("marshal %q kind %q: %w: the config is not an object", path, kind, ErrMarshal)
+	}
+	if !m.resolvesInline(kind) && m.resolvesWrapper(kind) {
+		buf.WriteByte('{')
+		writeString(buf, kind)
+		buf.WriteByte(':')
+		buf.Write(b)
+		buf.WriteByte('}')
+		return nil
+	}
+	buf.WriteByte('{')
+	writeString(buf, m.Provider.Discriminator())
+	buf.WriteByte(':')
+	writeString(buf, kind)
+	if len(b) > 2 {
+		buf.WriteByte(',')
+	}
+	buf.Write(b[1:])
+	return nil
+}
+
+// resolvesInline reports whether the Provider resolves the kind from the discriminator key, such as {"@kind":"http"}.
+func (m *Marshaler) resolvesInline(kind string) bool {
+	n := &ast.Node{Kind: ast.Object, Members: []ast.Member{
+		{Key: m.Provider.Discriminator(), Value: &ast.Node{Kind: ast.String, Value: kind}},
+	}}
+	k, _ := m.Provider.Resolve(n)
+	return k == kind
+}
+
+// resolvesWrapper reports whether the Provider resolves the kind from the key holding the config, such as {"http":{}}.
+func (m *Marshaler) resolvesWrapper(kind string) bool {
+	n := &ast.Node{Kind: ast.Object, Members: []ast.Member{
+		{Key: kind, Value: &ast.Node{Kind: ast.Object}},
+	}}
+	k, _ := m.Provider.Resolve(n)
+	return k == kind
+}
+
+// encodeCustom delegates to the first of MarshalFuncfg, MarshalJSON and MarshalText of the value or of the pointer to it.
+// It reports false if the value has no custom encoding.
+func (m *Marshaler) encodeCustom(buf *bytes.Buffer, path string, v reflect.Value) (bool, error) {
+	if !v.CanInterface() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return false, nil
+	}
+	if !isMarshaler(v.Type()) {
+		if !v.CanAddr() || !isMarshaler(reflect.PtrTo(v.Type())) {
+			return false, nil
+		}
+		v = v.Addr()
+	}
+	var b []byte
+	var err error
+	switch u := v.Interface().(type) {
+	case FuncfgMarshaler:
+		b, err = u.MarshalFuncfg(m)
+	case json.Marshaler:
+		b, err = u.MarshalJSON()
+	case encoding.TextMarshaler:
+		b, err = u.MarshalText()
+		if err == nil {
+			writeString(buf, string(b))
+			return true, nil
+		}
+	}
+	if err == nil {
+		err = json.Compact(buf, b)
+	}
+	if err != nil {
+		return true, fmt.Errorf("marshal %q into %s: %w", path, v.Type(), err)
+	}
+	return true, nil
+}
+
+func isMarshaler(typ reflect.Type) bool {
+	return typ.Implements(funcfgMarshalerType) ||
+		typ.Implements(jsonMarshalerType) ||
+		typ.Implements(textMarshalerType)
+}
+
+func (m *Marshaler) encodeStruct(buf *bytes.Buffer, path string, v reflect.Value) error {
+	buf.WriteByte('{')
+	first := true
+	for _, f := range cachedStructFields(v.Type()).list {
+		fv, ok := fieldValue(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		writeString(buf, f.name)
+		buf.WriteByte(':')
+		fieldPath := appendPath(path, f.name)
+		if f.quoted {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() != reflect.Ptr {
+				// The ",string" option, the scalar is quoted.
+				scalar := bytes.Buffer{}
+				err := encodeScalar(&scalar, fieldPath, fv)
+				if err != nil {
+					return err
+				}
+				writeString(buf, scalar.String())
+				continue
+			}
+		}
+		err := m.encode(buf, fieldPath, fv)
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// fieldValue returns the field with the index, it reports false if it is in a nil embedded struct.
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func (m *Marshaler) encodeMap(buf *bytes.Buffer, path string, v reflect.Value) error {
+	if v.IsNil() {
+		buf.WriteString("null")
+		return nil
+	}
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := mapKeyString(iter.Key())
+		if err != nil {
+			return fmt.Errorf("marshal %q: %w", path, err)
+		}
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	buf.WriteByte('{')
+	for i, e := range entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeString(buf, e.key)
+		buf.WriteByte(':')
+		err := m.encode(buf, appendPath(path, e.key), e.value)
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// mapKeyString returns the object key of the map key, the inverse of mapKey.
+func mapKeyString(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if k.Type().Implements(textMarshalerType) && k.CanInterface() {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", nil
+		}
+		b, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("%w: unsupported map key type %s", ErrMarshal, k.Type())
+}
+
+func (m *Marshaler) encodeArray(buf *bytes.Buffer, path string, v reflect.Value) error {
+	buf.WriteByte('[')
+	for i := 0; i != v.Len(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		err := m.encode(buf, appendPath(path, strconv.Itoa(i)), v.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+// encodeScalar encodes the basic types without going through encoding/json,
+// the values of unexported embedded structs cannot be passed to it.
+func encodeScalar(buf *bytes.Buffer, path string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		buf.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		var f interface{} = v.Float()
+		if v.Kind() == reflect.Float32 {
+			f = float32(v.Float())
+		}
+		b, err := json.Marshal(f)
+		if err != nil {
+			return fmt.Errorf("marshal %q: %w", path, err)
+		}
+		buf.Write(b)
+	case reflect.String:
+		writeString(buf, v.String())
+	default:
+		return fmt.Errorf("marshal %q: %w: unsupported type %s", path, ErrMarshal, v.Type())
+	}
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+// isEmptyValue reports whether the value is omitted by the omitempty option, as encoding/json does.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
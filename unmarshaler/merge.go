@@ -0,0 +1,265 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
+	"github.com/wzshiming/funcfg/types"
+)
+
+// ArrayStrategy is how an overlay combines an array with the array it overlays.
+type ArrayStrategy uint8
+
+const (
+	// ArrayReplace replaces the array, as RFC 7396 does.
+	ArrayReplace ArrayStrategy = iota
+	// ArrayAppend appends the elements of the overlay.
+	ArrayAppend
+	// ArrayMergeByKey merges the objects with the same key member and appends the others.
+	ArrayMergeByKey
+)
+
+// MergeOptions are the rules of merging documents.
+type MergeOptions struct {
+	// Provider finds the kind of the objects, an overlay of another kind replaces the object instead of merging into it.
+	// The kinds are not compared if it is nil.
+	Provider types.Provider
+	// Arrays is the strategy of the arrays not in Paths.
+	Arrays ArrayStrategy
+	// Paths is the strategies of the arrays by their JSON Pointer in the document,
+	// a "*" token matches any key or index, such as "/servers/*/routes".
+	Paths map[string]ArrayStrategy
+	// Key is the member identifying the objects of the arrays merged by key, "name" if it is empty.
+	Key string
+}
+
+// Merge merges the JSON documents in order, each one is an overlay of the result of the ones before it,
+// following RFC 7396 JSON Merge Patch and the rules of the options.
+func Merge(opts MergeOptions, docs ...[]byte) ([]byte, error) {
+	nodes := make([]*ast.Node, 0, len(docs))
+	for _, doc := range docs {
+		n, err := ast.Parse(&ast.Source{Data: doc})
+		if err != nil {
+			return nil, syntaxError(err)
+		}
+		nodes = append(nodes, n)
+	}
+	n, err := MergeNodes(opts, nodes...)
+	if err != nil {
+		return nil, err
+	}
+	return n.Bytes(), nil
+}
+
+// MergeNodes merges the parsed documents in order as Merge does,
+// the nodes keep their sources so that the errors of decoding the result locate the document of each value.
+func MergeNodes(opts MergeOptions, nodes ...*ast.Node) (*ast.Node, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no document to merge")
+	}
+	if opts.Key == "" {
+		opts.Key = "name"
+	}
+	n := nodes[0]
+	for _, overlay := range nodes[1:] {
+		n = opts.merge("", n, overlay)
+	}
+	return n, nil
+}
+
+// UnmarshalMerge merges the documents in order as MergeNodes does and decodes the result into the value pointed to by i,
+// the Provider of the Unmarshaler finds the kinds if the options have none.
+func (d *Unmarshaler) UnmarshalMerge(opts MergeOptions, i interface{}, sources ...*ast.Source) error {
+	if opts.Provider == nil {
+		opts.Provider = d.Provider
+	}
+	nodes := make([]*ast.Node, 0, len(sources))
+	for _, src := range sources {
+		n, err := ast.Parse(src)
+		if err != nil {
+			return syntaxError(err)
+		}
+		nodes = append(nodes, n)
+	}
+	n, err := MergeNodes(opts, nodes...)
+	if err != nil {
+		return err
+	}
+	return d.UnmarshalNode(n, i)
+}
+
+func (o *MergeOptions) merge(path string, base, overlay *ast.Node) *ast.Node {
+	switch overlay.Kind {
+	case ast.Object:
+		if base == nil || base.Kind != ast.Object || o.otherKind(base, overlay) {
+			return o.mergeObject(path, nil, overlay)
+		}
+		return o.mergeObject(path, base, overlay)
+	case ast.Array:
+		if base != nil && base.Kind == ast.Array {
+			switch o.strategy(path) {
+			case ArrayAppend:
+				return withElems(base, append(append([]*ast.Node{}, base.Elems...), overlay.Elems...))
+			case ArrayMergeByKey:
+				return o.mergeByKey(path, base, overlay)
+			}
+		}
+	}
+	return overlay
+}
+
+// mergeObject applies the members of the overlay to the base, a null member removes the key.
+func (o *MergeOptions) mergeObject(path string, base, overlay *ast.Node) *ast.Node {
+	var members []ast.Member
+	index := map[string]int{}
+	at := overlay
+	if base != nil {
+		at = base
+		for _, m := range base.Members {
+			if i, ok := index[m.Key]; ok {
+				// The last one wins.
+				members[i] = m
+				continue
+			}
+			index[m.Key] = len(members)
+			members = append(members, m)
+		}
+	}
+	removed := false
+	for _, m := range overlay.Members {
+		i, ok := index[m.Key]
+		if m.Value.Kind == ast.Null {
+			if ok && members[i].Value != nil {
+				members[i].Value = nil
+				removed = true
+			}
+			continue
+		}
+		if ok && members[i].Value != nil {
+			members[i].Value = o.merge(appendPath(path, m.Key), members[i].Value, m.Value)
+			continue
+		}
+		m.Value = o.merge(appendPath(path, m.Key), nil, m.Value)
+		if ok {
+			members[i] = m
+			continue
+		}
+		index[m.Key] = len(members)
+		members = append(members, m)
+	}
+	if removed {
+		kept := members[:0]
+		for _, m := range members {
+			if m.Value != nil {
+				kept = append(kept, m)
+			}
+		}
+		members = kept
+	}
+	if members == nil {
+		members = []ast.Member{}
+	}
+	return &ast.Node{
+		Kind:    ast.Object,
+		Source:  at.Source,
+		Offset:  at.Offset,
+		Members: members,
+	}
+}
+
+// mergeByKey merges the objects of the overlay into the objects of the base with the same key and appends the others.
+func (o *MergeOptions) mergeByKey(path string, base, overlay *ast.Node) *ast.Node {
+	elems := append([]*ast.Node{}, base.Elems...)
+	for _, elem := range overlay.Elems {
+		key, ok := elem.Lookup(o.Key)
+		if ok && elem.Kind == ast.Object {
+			if i := indexByKey(elems, o.Key, key); i >= 0 {
+				elems[i] = o.merge(appendPath(path, strconv.Itoa(i)), elems[i], elem)
+				continue
+			}
+		}
+		elems = append(elems, elem)
+	}
+	return withElems(base, elems)
+}
+
+// indexByKey returns the index of the object with the key, the keys are strings, numbers or bools.
+func indexByKey(elems []*ast.Node, name string, key *ast.Node) int {
+	switch key.Kind {
+	case ast.String, ast.Number, ast.Bool:
+	default:
+		return -1
+	}
+	for i, elem := range elems {
+		if elem.Kind != ast.Object {
+			continue
+		}
+		k, ok := elem.Lookup(name)
+		if ok && k.Kind == key.Kind && k.Value == key.Value {
+			return i
+		}
+	}
+	return -1
+}
+
+func withElems(base *ast.Node, elems []*ast.Node) *ast.Node {
+	return &ast.Node{
+		Kind:   ast.Array,
+		Source: base.Source,
+		Offset: base.Offset,
+		Elems:  elems,
+	}
+}
+
+// otherKind reports whether the overlay is a component of another kind than the base.
+func (o *MergeOptions) otherKind(base, overlay *ast.Node) bool {
+	if o.Provider == nil {
+		return false
+	}
+	overlayKind, _ := o.Provider.Resolve(overlay)
+	if overlayKind == "" {
+		return false
+	}
+	baseKind, _ := o.Provider.Resolve(base)
+	return baseKind != "" && baseKind != overlayKind
+}
+
+// strategy returns the strategy of the array at the path.
+func (o *MergeOptions) strategy(path string) ArrayStrategy {
+	if s, ok := o.Paths[path]; ok {
+		return s
+	}
+	patterns := make([]string, 0, len(o.Paths))
+	for pattern := range o.Paths {
+		if matchPath(pattern, path) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return o.Arrays
+	}
+	// The last in order is the most specific, as "*" sorts before the letters and digits.
+	sort.Strings(patterns)
+	return o.Paths[patterns[len(patterns)-1]]
+}
+
+// matchPath reports whether the JSON Pointer matches the pattern with "*" tokens.
+func matchPath(pattern, path string) bool {
+	if !strings.Contains(pattern, "*") {
+		return false
+	}
+	pt := strings.Split(pattern, "/")
+	ps := strings.Split(path, "/")
+	if len(pt) != len(ps) {
+		return false
+	}
+	for i := range pt {
+		if pt[i] != "*" && pt[i] != ps[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// pointerKey replaces the object with a copy of the value the JSON Pointer locates, such as {"$ref":"#/common/tls"}.
+const pointerKey = "$ref"
+
+// maxExpansion is the limit of the nodes the $ref objects of a decode expand to,
+// it stops nested references, such as the billion laughs attack, from growing without bound.
+const maxExpansion = 1000000
+
+// pointers resolves the $ref objects of a decode.
+type pointers struct {
+	d *Unmarshaler
+	// docs is the documents by their URI, the one being decoded is named by its source.
+	docs map[string]*ast.Node
+	// done is the nodes with the $ref objects in them replaced.
+	done map[*ast.Node]*ast.Node
+	// active is the paths of the $ref objects being resolved, to detect cycles.
+	active map[*ast.Node]string
+	// expanded is the count of the nodes the $ref objects expand to, sizes the expanded size of the resolved nodes.
+	expanded int
+	sizes    map[*ast.Node]int
+}
+
+// resolvePointers returns the tree with the $ref objects replaced by the values they point to,
+// the values are shared and the tree is copied only where it changes.
+func (d *Unmarshaler) resolvePointers(path string, n *ast.Node) (*ast.Node, error) {
+	if !hasKey(n, isPointerKey) {
+		return n, nil
+	}
+	doc := ""
+	if n.Source != nil {
+		doc = n.Source.Name
+	}
+	p := &pointers{
+		d:      d,
+		docs:   map[string]*ast.Node{doc: n},
+		done:   map[*ast.Node]*ast.Node{},
+		active: map[*ast.Node]string{},
+		sizes:  map[*ast.Node]int{},
+	}
+	return p.resolve(doc, path, n)
+}
+
+func isPointerKey(key string) bool {
+	return key == pointerKey
+}
+
+func (p *pointers) resolve(doc string, path string, n *ast.Node) (*ast.Node, error) {
+	if r, ok := p.done[n]; ok {
+		return r, nil
+	}
+	r, err := p.resolveNode(doc, path, n)
+	if err != nil {
+		return nil, err
+	}
+	p.done[n] = r
+	return r, nil
+}
+
+func (p *pointers) resolveNode(doc string, path string, n *ast.Node) (*ast.Node, error) {
+	if ref, ok := n.Lookup(pointerKey); ok && n.Kind == ast.Object {
+		// The other keys beside $ref are ignored, or reported with DisallowUnknownFields.
+		if p.d.DisallowUnknownFields {
+			err := p.checkSiblings(path, n)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return p.follow(doc, path, n, ref)
+	}
+	return rewriteChildren(path, n, func(path string, n *ast.Node) (*ast.Node, error) {
+		return p.resolve(doc, path, n)
+	})
+}
+
+// checkSiblings reports the keys beside $ref in the object n.
+func (p *pointers) checkSiblings(path string, n *ast.Node) error {
+	var errs DecodeErrors
+	for _, m := range n.Members {
+		if isPointerKey(m.Key) {
+			continue
+		}
+		e := newDecodeError(appendPath(path, m.Key), nil, "", nil, fmt.Errorf("%w: %q is ignored beside %s", ErrReference, m.Key, pointerKey))
+		e.setPosition(m.KeyPos())
+		if !p.d.AllErrors {
+			return e
+		}
+		errs = append(errs, e)
+	}
+	return errs.err()
+}
+
+// follow returns the value the $ref of the object n points to, with the $ref objects in it resolved.
+func (p *pointers) follow(doc string, path string, n *ast.Node, ref *ast.Node) (*ast.Node, error) {
+	refPath := appendPath(path, pointerKey)
+	if ref.Kind != ast.String {
+		return nil, newDecodeError(refPath, ref, "", nil, fmt.Errorf("%w: %s must be a string", ErrReference, pointerKey))
+	}
+	if first, ok := p.active[n]; ok {
+		return nil, newDecodeError(first, ref, "", nil, fmt.Errorf("%w: cycle at %q", ErrReference, ref.Value))
+	}
+	target, targetDoc, err := p.lookup(doc, ref.Value)
+	if err != nil {
+		return nil, newDecodeError(refPath, ref, "", nil, err)
+	}
+	p.active[n] = refPath
+	defer delete(p.active, n)
+	r, err := p.resolve(targetDoc, path, target)
+	if err != nil {
+		return nil, err
+	}
+	p.expanded += p.size(r)
+	if p.expanded > maxExpansion {
+		return nil, newDecodeError(refPath, ref, "", nil, fmt.Errorf("%w: exceeded the expansion budget at %q", ErrReference, ref.Value))
+	}
+	return r, nil
+}
+
+// size returns the count of the nodes in the resolved tree, counting the shared values each time.
+func (p *pointers) size(n *ast.Node) int {
+	if s, ok := p.sizes[n]; ok {
+		return s
+	}
+	s := 1
+	for _, e := range n.Elems {
+		s += p.size(e)
+	}
+	for _, m := range n.Members {
+		s += p.size(m.Value)
+	}
+	p.sizes[n] = s
+	return s
+}
+
+// lookup returns the value the reference points to and the URI of the document holding it.
+func (p *pointers) lookup(doc string, ref string) (*ast.Node, string, error) {
+	uri, fragment := ref, ""
+	if i := strings.Index(ref, "#"); i >= 0 {
+		uri, fragment = ref[:i], ref[i+1:]
+	}
+	if uri != "" {
+		var err error
+		uri, err = resolveURI(doc, uri)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %q: %v", ErrReference, ref, err)
+		}
+		doc = uri
+	}
+	root, err := p.document(doc)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %q: %v", ErrReference, ref, err)
+	}
+	fragment, err = url.PathUnescape(fragment)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %q: %v", ErrReference, ref, err)
+	}
+	n, err := lookupPointer(root, fragment)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %q: %v", ErrReference, ref, err)
+	}
+	return n, doc, nil
+}
+
+// document returns the parsed document with the URI, loading it with the RefLoader the first time.
+func (p *pointers) document(uri string) (*ast.Node, error) {
+	if n, ok := p.docs[uri]; ok {
+		return n, nil
+	}
+	if p.d.RefLoader == nil {
+		return nil, fmt.Errorf("references to other documents need a RefLoader")
+	}
+	data, err := p.d.RefLoader(uri)
+	if err != nil {
+		return nil, err
+	}
+	n, err := parseFile(&ast.Source{Name: uri, Data: data})
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+	p.docs[uri] = n
+	return n, nil
+}
+
+// resolveURI returns the URI relative to the URI of the document,
+// relative file paths stay relative.
+func resolveURI(doc string, uri string) (string, error) {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if doc == "" || ref.IsAbs() || strings.HasPrefix(ref.Path, "/") {
+		return ref.String(), nil
+	}
+	base, err := url.Parse(doc)
+	if err != nil {
+		return "", err
+	}
+	if base.IsAbs() || strings.HasPrefix(base.Path, "/") {
+		return base.ResolveReference(ref).String(), nil
+	}
+	return path.Join(path.Dir(base.Path), ref.Path), nil
+}
+
+// lookupPointer returns the value the JSON Pointer locates in the tree.
+func lookupPointer(n *ast.Node, pointer string) (*ast.Node, error) {
+	if pointer == "" {
+		return n, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("%q is not a JSON Pointer", pointer)
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch n.Kind {
+		case ast.Object:
+			v, ok := n.Lookup(token)
+			if !ok {
+				return nil, fmt.Errorf("%q not found", token)
+			}
+			n = v
+		case ast.Array:
+			i, err := strconv.ParseUint(token, 10, 0)
+			if err != nil || i >= uint64(len(n.Elems)) || (len(token) > 1 && token[0] == '0') {
+				return nil, fmt.Errorf("index %q out of range", token)
+			}
+			n = n.Elems[i]
+		default:
+			return nil, fmt.Errorf("%q not found in %s", token, n.Kind)
+		}
+	}
+	return n, nil
+}
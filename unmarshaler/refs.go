@@ -0,0 +1,209 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+const (
+	// nameKey names the component of the object so that it can be referenced.
+	nameKey = "@name"
+	// refKey makes the object the component with the name.
+	refKey = "@ref"
+)
+
+// registry is the named components of a decode, shared by the copies of the Unmarshaler made for it.
+type registry struct {
+	root *ast.Node
+	// defs is collected from root on the first use of a name.
+	defs  map[string]*definition
+	stack []*definition
+}
+
+type definition struct {
+	name   string
+	path   string
+	kind   string
+	config *ast.Node
+
+	building bool
+	built    bool
+	value    reflect.Value
+	err      error
+}
+
+// isReservedKey reports whether the key is handled by the Unmarshaler rather than decoded.
+func isReservedKey(key string) bool {
+	return strings.EqualFold(key, nameKey) || strings.EqualFold(key, refKey)
+}
+
+// lookupFold returns the last member matching the key case-insensitively.
+func lookupFold(n *ast.Node, key string) (*ast.Member, bool) {
+	if n == nil || n.Kind != ast.Object {
+		return nil, false
+	}
+	for i := len(n.Members) - 1; i >= 0; i-- {
+		if strings.EqualFold(n.Members[i].Key, key) {
+			return &n.Members[i], true
+		}
+	}
+	return nil, false
+}
+
+// collect finds the named components of the tree, it is done once per decode.
+func (d *Unmarshaler) collect() error {
+	refs := d.refs
+	if refs.defs != nil {
+		return nil
+	}
+	refs.defs = map[string]*definition{}
+	var errs DecodeErrors
+	d.collectNode("", refs.root, &errs)
+	if d.AllErrors {
+		return errs.err()
+	}
+	if len(errs) != 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (d *Unmarshaler) collectNode(path string, n *ast.Node, errs *DecodeErrors) {
+	switch n.Kind {
+	case ast.Array:
+		for i, elem := range n.Elems {
+			d.collectNode(appendPath(path, strconv.Itoa(i)), elem, errs)
+		}
+		return
+	case ast.Object:
+	default:
+		return
+	}
+
+	if kind, config := d.Provider.Resolve(n); kind != "" {
+		if m, ok := lookupFold(config, nameKey); ok {
+			err := d.define(configPath(path, n, config), kind, config, m)
+			if err != nil {
+				e := newDecodeError(appendPath(configPath(path, n, config), m.Key), m.Value, kind, nil, err)
+				*errs = append(*errs, e)
+			}
+		}
+	}
+	for _, m := range n.Members {
+		d.collectNode(appendPath(path, m.Key), m.Value, errs)
+	}
+}
+
+func (d *Unmarshaler) define(path string, kind string, config *ast.Node, m *ast.Member) error {
+	if m.Value.Kind != ast.String {
+		return fmt.Errorf("%w: the name must be a string", ErrReference)
+	}
+	name := m.Value.Value
+	if _, ok := d.refs.defs[name]; ok {
+		return fmt.Errorf("%w: %q is defined more than once", ErrReference, name)
+	}
+	d.refs.defs[name] = &definition{
+		name:   name,
+		path:   path,
+		kind:   kind,
+		config: config,
+	}
+	return nil
+}
+
+// decodeNamed decodes the component defined with a name, it is built once and shared with the references to it.
+func (d *Unmarshaler) decodeNamed(path string, fun reflect.Value, kind string, config *ast.Node, m *ast.Member, value reflect.Value) error {
+	err := d.collect()
+	if err != nil {
+		return err
+	}
+	def, ok := d.refs.defs[m.Value.Value]
+	if !ok || def.config != config {
+		// The name is invalid or defined more than once, which is reported by collect,
+		// or the config is not part of the tree of the decode.
+		return d.unmarshalKind(path, fun, kind, config, value)
+	}
+	if def.building {
+		return fmt.Errorf("%w: cycle %s", ErrReference, d.refs.cycle(def))
+	}
+	if !def.built {
+		err := d.build(def, value)
+		if err != nil {
+			return err
+		}
+	} else if def.err != nil {
+		// Reported by the reference that built it.
+		return nil
+	}
+	return setResult(value, def.value)
+}
+
+// decodeRef decodes the object with the reference into the value of the component with the name.
+func (d *Unmarshaler) decodeRef(path string, n *ast.Node, m *ast.Member, value reflect.Value) error {
+	if m.Value.Kind != ast.String {
+		return fmt.Errorf("%w: the reference must be a string", ErrReference)
+	}
+	err := d.collect()
+	if err != nil {
+		return err
+	}
+	name := m.Value.Value
+	def, ok := d.refs.defs[name]
+	if !ok {
+		return fmt.Errorf("%w: %q is not defined", ErrReference, name)
+	}
+	if def.building {
+		return fmt.Errorf("%w: cycle %s", ErrReference, d.refs.cycle(def))
+	}
+	if !def.built {
+		err := d.build(def, value)
+		if err != nil {
+			return err
+		}
+	} else if def.err != nil {
+		return fmt.Errorf("%w: %q failed to build", ErrReference, name)
+	}
+
+	err = d.checkUnknownFields(path, n, map[string]bool{}, nil)
+	if err != nil {
+		return err
+	}
+	return setResult(value, def.value)
+}
+
+// build calls the constructor of the definition, value is the site that needs it first.
+func (d *Unmarshaler) build(def *definition, value reflect.Value) error {
+	fun, ok := d.Provider.Find(def.kind)
+	if !ok {
+		def.built = true
+		def.err = fmt.Errorf("not found %q in provider", def.kind)
+		return newDecodeError(def.path, def.config, def.kind, value.Type().Elem(), def.err)
+	}
+	def.building = true
+	d.refs.stack = append(d.refs.stack, def)
+	def.value, def.err = d.construct(def.path, fun, def.kind, def.config, value)
+	d.refs.stack = d.refs.stack[:len(d.refs.stack)-1]
+	def.building = false
+	def.built = true
+	return def.err
+}
+
+// cycle returns the names of the definitions being built from def to itself.
+func (r *registry) cycle(def *definition) string {
+	names := []string{}
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		names = append(names, r.stack[i].name)
+		if r.stack[i] == def {
+			break
+		}
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	names = append(names, def.name)
+	return strings.Join(names, " -> ")
+}
@@ -0,0 +1,69 @@
+package unmarshaler
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// decodeScalar decodes the node into the value v points to without going through encoding/json
+// for the basic types, the others and all errors are left to encoding/json.
+// Types with custom decoding are handled by decodeCustom before.
+func decodeScalar(n *ast.Node, v reflect.Value) error {
+	e := v.Elem()
+	switch e.Kind() {
+	case reflect.Interface:
+		if e.NumMethod() == 0 {
+			if n.Kind == ast.Null {
+				e.Set(reflect.Zero(e.Type()))
+				return nil
+			}
+			e.Set(reflect.ValueOf(n.Interface()))
+			return nil
+		}
+	case reflect.String:
+		if n.Kind == ast.String {
+			e.SetString(n.Value)
+			return nil
+		}
+	case reflect.Bool:
+		if n.Kind == ast.Bool {
+			e.SetBool(n.Value == "true")
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n.Kind == ast.Number {
+			i, err := strconv.ParseInt(n.Value, 10, e.Type().Bits())
+			if err == nil {
+				e.SetInt(i)
+				return nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n.Kind == ast.Number {
+			u, err := strconv.ParseUint(n.Value, 10, e.Type().Bits())
+			if err == nil {
+				e.SetUint(u)
+				return nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if n.Kind == ast.Number {
+			f, err := strconv.ParseFloat(n.Value, e.Type().Bits())
+			if err == nil {
+				e.SetFloat(f)
+				return nil
+			}
+		}
+	}
+	if n.Kind == ast.Null {
+		switch e.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			e.Set(reflect.Zero(e.Type()))
+		}
+		return nil
+	}
+	return json.Unmarshal(n.Bytes(), v.Interface())
+}
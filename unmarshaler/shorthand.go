@@ -0,0 +1,62 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// shorthand resolves the string form of a component into its kind and config,
+// the kind is empty if the string is not a shorthand of the provider.
+func (d *Unmarshaler) shorthand(n *ast.Node) (string, *ast.Node, error) {
+	kind, parse := d.Provider.Shorthand(n.Value)
+	if kind == "" {
+		return "", nil, nil
+	}
+	data, err := parse(n.Value)
+	if err != nil {
+		return kind, nil, fmt.Errorf("shorthand %q: %w", n.Value, err)
+	}
+	config, err := ast.Parse(&ast.Source{Data: data})
+	if err != nil {
+		return kind, nil, fmt.Errorf("shorthand %q: %w", n.Value, err)
+	}
+	// Locate the errors of the config at the string holding it.
+	relocate(config, n)
+	return kind, config, nil
+}
+
+// acceptsShorthand reports whether a string decoded into the type may be the shorthand of a component,
+// the types a string decodes into keep it as it is.
+func acceptsShorthand(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return false
+	case reflect.Interface:
+		if typ.NumMethod() == 0 {
+			return false
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return false
+		}
+	}
+	return !isCustom(typ)
+}
+
+// relocate moves the tree of the node to the position of the node at, the tree is generated from it.
+func relocate(n *ast.Node, at *ast.Node) {
+	n.Source = at.Source
+	n.Offset = at.Offset
+	for i := range n.Members {
+		n.Members[i].KeyOffset = at.Offset
+		relocate(n.Members[i].Value, at)
+	}
+	for _, elem := range n.Elems {
+		relocate(elem, at)
+	}
+}
@@ -0,0 +1,72 @@
+package unmarshaler
+
+import (
+	"strconv"
+
+	"github.com/wzshiming/funcfg/ast"
+)
+
+// rewriteChildren returns the node with its members or elements replaced by f,
+// the node is copied only if one of them changes.
+func rewriteChildren(path string, n *ast.Node, f func(path string, n *ast.Node) (*ast.Node, error)) (*ast.Node, error) {
+	switch n.Kind {
+	case ast.Object:
+		var members []ast.Member
+		for i, m := range n.Members {
+			v, err := f(appendPath(path, m.Key), m.Value)
+			if err != nil {
+				return nil, err
+			}
+			if v != m.Value && members == nil {
+				members = make([]ast.Member, len(n.Members))
+				copy(members, n.Members)
+			}
+			if members != nil {
+				members[i].Value = v
+			}
+		}
+		if members != nil {
+			c := *n
+			c.Raw = nil
+			c.Members = members
+			return &c, nil
+		}
+	case ast.Array:
+		var elems []*ast.Node
+		for i, elem := range n.Elems {
+			v, err := f(appendPath(path, strconv.Itoa(i)), elem)
+			if err != nil {
+				return nil, err
+			}
+			if v != elem && elems == nil {
+				elems = make([]*ast.Node, len(n.Elems))
+				copy(elems, n.Elems)
+			}
+			if elems != nil {
+				elems[i] = v
+			}
+		}
+		if elems != nil {
+			c := *n
+			c.Raw = nil
+			c.Elems = elems
+			return &c, nil
+		}
+	}
+	return n, nil
+}
+
+// hasKey reports whether an object in the tree has a member for which match is true.
+func hasKey(n *ast.Node, match func(key string) bool) bool {
+	for _, m := range n.Members {
+		if match(m.Key) || hasKey(m.Value, match) {
+			return true
+		}
+	}
+	for _, elem := range n.Elems {
+		if hasKey(elem, match) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,14 @@
 package unmarshaler
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"encoding"
 	"fmt"
+	"io/fs"
 	"reflect"
 	"strconv"
-	"strings"
 
+	"github.com/wzshiming/funcfg/ast"
 	"github.com/wzshiming/funcfg/types"
 	"github.com/wzshiming/inject"
 )
@@ -18,237 +18,719 @@ var (
 	ErrMustBeAssignable = fmt.Errorf("must be assignable")
 	ErrFormat           = fmt.Errorf("format error")
 	ErrIsInvalid        = fmt.Errorf("is invalid")
+	ErrUnknownField     = fmt.Errorf("unknown field")
+	ErrValidation       = fmt.Errorf("validation failed")
+	ErrReference        = fmt.Errorf("invalid reference")
+	ErrInterpolation    = fmt.Errorf("interpolation failed")
+	ErrInclude          = fmt.Errorf("invalid include")
+	ErrMarshal          = fmt.Errorf("cannot marshal")
 )
 
 type Unmarshaler struct {
 	Ctx      context.Context
 	Inject   *inject.Injector
 	Provider types.Provider
+
+	// Filename is the name of the input reported in error positions.
+	Filename string
+	// AllErrors continues decoding after an error and returns DecodeErrors listing every error.
+	AllErrors bool
+	// DisallowUnknownFields reports the keys of an object that match no field of the struct
+	// or of the struct parameters of a kind constructor, and the keys beside $ref.
+	DisallowUnknownFields bool
+	// Merge decodes on top of the existing value instead of zeroing it first,
+	// struct fields, map entries, slice elements and pointer targets absent from the config are kept.
+	Merge bool
+	// RefLoader loads the documents referenced by $ref objects other than the one being decoded,
+	// the URI is resolved against the name of the document holding the reference.
+	// Only references within the document are resolved if it is nil.
+	// The documents named .yaml or .yml are parsed as YAML, and .toml as TOML.
+	RefLoader func(uri string) ([]byte, error)
+	// FS is the files of the @include objects, the paths are relative to the file holding them,
+	// with Filename being the name of the input in FS. Includes are not resolved if it is nil.
+	// The files named .yaml or .yml are parsed as YAML, and .toml as TOML.
+	FS fs.FS
+	// Interpolate expands ${VAR}, ${VAR:-default} and ${VAR:?message} in the strings before decoding,
+	// a string that is a single expression may also be decoded into a number or a bool.
+	Interpolate bool
+	// Lookup returns the variables of Interpolate, os.LookupEnv is used if it is nil.
+	Lookup func(name string) (string, bool)
+
+	// node, path and config are the value being decoded by UnmarshalFuncfg,
+	// Unmarshal of the config decodes the node in place.
+	node   *ast.Node
+	path   string
+	config []byte
+
+	// refs is the named components of the decode.
+	refs *registry
 }
 
 func (d *Unmarshaler) Unmarshal(config []byte, i interface{}) error {
-	v := reflect.ValueOf(i)
-	return d.decode(config, v)
+	if d.node != nil && sameBytes(d.config, config) {
+		return d.unmarshalNode(d.path, d.node, i)
+	}
+	return d.unmarshalSource(&ast.Source{Name: d.Filename, Data: config}, i)
+}
+
+func (d *Unmarshaler) unmarshalSource(src *ast.Source, i interface{}) error {
+	n, err := ast.Parse(src)
+	if err != nil {
+		return syntaxError(err)
+	}
+	return d.UnmarshalNode(n, i)
+}
+
+// UnmarshalNode decodes the parsed tree into the value pointed to by i.
+func (d *Unmarshaler) UnmarshalNode(n *ast.Node, i interface{}) error {
+	return d.unmarshalNode("", n, i)
 }
 
-func (d *Unmarshaler) decodeArray(config []byte, v reflect.Value) error {
-	tmp := []json.RawMessage{}
-	err := json.Unmarshal(config, &tmp)
+func (d *Unmarshaler) unmarshalNode(path string, n *ast.Node, i interface{}) error {
+	if d.refs == nil {
+		var err error
+		n, err = d.prepare(path, n)
+		if err != nil {
+			if d.AllErrors {
+				err = appendError(nil, err)
+			}
+			return err
+		}
+		sub := *d
+		sub.refs = &registry{root: n}
+		d = &sub
+	}
+	v := reflect.ValueOf(i)
+	err := d.decode(path, n, v)
 	if err != nil {
+		if d.AllErrors {
+			err = appendError(nil, err)
+		}
 		return err
 	}
+	return nil
+}
+
+// prepare resolves the @include and $ref objects and the interpolation of the tree before decoding.
+func (d *Unmarshaler) prepare(path string, n *ast.Node) (*ast.Node, error) {
+	n, err := d.resolveIncludes(path, n)
+	if err != nil {
+		return nil, err
+	}
+	n, err = d.resolvePointers(path, n)
+	if err != nil {
+		return nil, err
+	}
+	if d.Interpolate {
+		return d.interpolate(path, n)
+	}
+	return n, nil
+}
+
+func (d *Unmarshaler) decodeArray(path string, n *ast.Node, v reflect.Value) error {
+	tmp := n.Elems
+	var errs DecodeErrors
 	l := len(tmp)
-	v.Set(reflect.Zero(v.Type()))
+	if !d.Merge {
+		v.Set(reflect.Zero(v.Type()))
+	}
 	if l > v.Len() {
 		l = v.Len()
 	}
 	for i := 0; i != l; i++ {
-		err := d.decode(tmp[i], v.Index(i).Addr())
+		err := d.decode(appendPath(path, strconv.Itoa(i)), tmp[i], v.Index(i).Addr())
 		if err != nil {
-			return err
+			if !d.AllErrors {
+				return err
+			}
+			errs = appendError(errs, err)
 		}
 	}
-	return nil
+	return errs.err()
 }
 
-func (d *Unmarshaler) decodeSlice(config []byte, v reflect.Value) error {
-	tmp := []json.RawMessage{}
-	err := json.Unmarshal(config, &tmp)
-	if err != nil {
-		return err
-	}
-	v.Set(reflect.MakeSlice(v.Type(), len(tmp), len(tmp)))
+func (d *Unmarshaler) decodeSlice(path string, n *ast.Node, v reflect.Value) error {
+	tmp := n.Elems
+	var errs DecodeErrors
+	slice := reflect.MakeSlice(v.Type(), len(tmp), len(tmp))
+	if d.Merge {
+		reflect.Copy(slice, v)
+	}
+	v.Set(slice)
 	for i := 0; i != len(tmp); i++ {
-		err := d.decode(tmp[i], v.Index(i).Addr())
+		err := d.decode(appendPath(path, strconv.Itoa(i)), tmp[i], v.Index(i).Addr())
 		if err != nil {
-			return err
+			if !d.AllErrors {
+				return err
+			}
+			errs = appendError(errs, err)
 		}
 	}
-	return nil
+	return errs.err()
 }
 
-func (d *Unmarshaler) decodeMap(config []byte, v reflect.Value) error {
-	tmp := map[string]json.RawMessage{}
-	err := json.Unmarshal(config, &tmp)
-	if err != nil {
-		return err
-	}
+func (d *Unmarshaler) decodeMap(path string, n *ast.Node, v reflect.Value) error {
+	tmp := n.Members
+	var errs DecodeErrors
 	typ := v.Type()
-	v.Set(reflect.MakeMapWithSize(typ, len(tmp)))
-	for key, raw := range tmp {
+	if !isMapKey(typ.Key()) {
+		return fmt.Errorf("unsupported map key type %v", typ.Key())
+	}
+	if !d.Merge || v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(typ, len(tmp)))
+	}
+	for i := range tmp {
+		m := &tmp[i]
+		key, err := mapKey(m.Key, typ.Key())
+		if err != nil {
+			e := newDecodeError(appendPath(path, m.Key), nil, "", typ.Key(), err)
+			e.setPosition(m.KeyPos())
+			if !d.AllErrors {
+				return e
+			}
+			errs = append(errs, e)
+			continue
+		}
 		val := reflect.New(typ.Elem())
-		err := d.decode(raw, val)
+		if d.Merge {
+			if old := v.MapIndex(key); old.IsValid() {
+				val.Elem().Set(old)
+			}
+		}
+		err = d.decode(appendPath(path, m.Key), m.Value, val)
 		if err != nil {
-			return err
+			if !d.AllErrors {
+				return err
+			}
+			errs = appendError(errs, err)
+			continue
 		}
-		v.SetMapIndex(reflect.ValueOf(key), val.Elem())
+		v.SetMapIndex(key, val.Elem())
 	}
-	return nil
+	return errs.err()
 }
 
-func (d *Unmarshaler) decodeStruct(config []byte, v reflect.Value) error {
-	tmp := map[string]json.RawMessage{}
-	err := json.Unmarshal(config, &tmp)
-	if err != nil {
+// isMapKey reports whether the map key type can be decoded from an object key,
+// the same as encoding/json it is a string, an integer or an encoding.TextUnmarshaler.
+func isMapKey(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return reflect.PtrTo(typ).Implements(textUnmarshalerType)
+}
+
+// mapKey returns the object key converted to the map key type.
+func mapKey(key string, typ reflect.Type) (reflect.Value, error) {
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		kv := reflect.New(typ)
+		err := kv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return kv.Elem(), nil
+	}
+	kv := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		kv.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || kv.OverflowInt(i) {
+			return reflect.Value{}, fmt.Errorf("%w: key %q is not %v", ErrFormat, key, typ)
+		}
+		kv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || kv.OverflowUint(u) {
+			return reflect.Value{}, fmt.Errorf("%w: key %q is not %v", ErrFormat, key, typ)
+		}
+		kv.SetUint(u)
+	}
+	return kv, nil
+}
+
+func (d *Unmarshaler) decodeStruct(path string, n *ast.Node, v reflect.Value) error {
+	if !d.Merge {
+		v.Set(reflect.Zero(v.Type()))
+	}
+	used := map[string]bool{}
+	err := d.decodeFields(path, n, v, used)
+	if err != nil && !d.AllErrors {
 		return err
 	}
-	for k, v := range tmp {
-		key := strings.ToLower(k)
-		if key != k {
-			tmp[key] = v
+	return d.checkUnknownFields(path, n, used, err)
+}
+
+// decodeFields decodes the members of the object into the fields of the struct and records the keys consumed in used.
+func (d *Unmarshaler) decodeFields(path string, n *ast.Node, v reflect.Value, used map[string]bool) error {
+	var errs DecodeErrors
+	fields := cachedStructFields(v.Type())
+	set := make([]*ast.Member, len(fields.list))
+	for j := range n.Members {
+		m := &n.Members[j]
+		i, ok := fields.lookup(m.Key)
+		if !ok {
+			continue
 		}
-	}
+		used[m.Key] = true
+		set[i] = m
 
-	typ := v.Type()
-	v.Set(reflect.Zero(typ))
-	num := typ.NumField()
-	for i := 0; i != num; i++ {
-		f := typ.Field(i)
-		name := strings.ToLower(f.Name)
-		if value, ok := f.Tag.Lookup("json"); ok {
-			n := strings.Split(value, ",")
-			if n[0] != "" {
-				name = n[0]
-				name = strings.ToLower(name)
+		err := d.decodeField(path, m, &fields.list[i], v)
+		if err != nil {
+			if !d.AllErrors {
+				return err
+			}
+			errs = appendError(errs, err)
+		}
+	}
+	for i := range fields.list {
+		if set[i] != nil {
+			continue
+		}
+		err := d.decodeAbsent(path, n, &fields.list[i], v)
+		if err != nil {
+			if !d.AllErrors {
+				return err
 			}
-			for _, arg := range n[1:] {
-				switch arg {
-				case "string":
-					d, _ := strconv.Unquote(string(tmp[name]))
-					tmp[name] = json.RawMessage(d)
+			errs = appendError(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return d.validateFields(path, n, v, set)
+}
+
+// validateFields checks the validate rules of the fields of the struct, set is the members they are decoded from.
+// The fields of the structs absent from the object n are checked as well, nil if the whole struct is absent.
+func (d *Unmarshaler) validateFields(path string, n *ast.Node, v reflect.Value, set []*ast.Member) error {
+	var errs DecodeErrors
+	fields := cachedStructFields(v.Type())
+	for i := range fields.list {
+		f := &fields.list[i]
+		var m *ast.Member
+		if set != nil {
+			m = set[i]
+		}
+		if m == nil && !f.hasDef {
+			if s, ok := absentStruct(v, f); ok {
+				err := d.validateFields(appendPath(path, f.name), n, s, nil)
+				if err != nil {
+					if !d.AllErrors {
+						return err
+					}
+					errs = appendError(errs, err)
 				}
 			}
 		}
+		if len(f.rules) == 0 {
+			continue
+		}
+		field, err := fieldByIndex(v, f.index)
+		if err == nil {
+			err = validateRules(f.rules, field)
+		}
+		if err != nil {
+			if m != nil {
+				err = wrapError(appendPath(path, m.Key), m.Value, "", field.Addr(), err)
+			} else {
+				err = wrapError(appendPath(path, f.name), n, "", field.Addr(), err)
+			}
+			if !d.AllErrors {
+				return err
+			}
+			errs = appendError(errs, err)
+		}
+	}
+	return errs.err()
+}
 
-		if c, ok := tmp[name]; ok {
-			field := v.Field(i)
-			field.Set(reflect.Zero(f.Type))
-			err := d.decode(c, field.Addr())
-			if err != nil {
+func (d *Unmarshaler) decodeField(path string, m *ast.Member, f *field, v reflect.Value) error {
+	path = appendPath(path, m.Key)
+	n := m.Value
+	field, err := fieldByIndex(v, f.index)
+	if err != nil {
+		return wrapError(path, n, "", reflect.Value{}, err)
+	}
+	if f.quoted {
+		n, err = unquoteField(n, f.typ)
+		if err != nil {
+			return wrapError(path, m.Value, "", field.Addr(), err)
+		}
+	}
+	if !d.Merge {
+		field.Set(reflect.Zero(f.typ))
+	}
+	return d.decode(path, n, field.Addr())
+}
+
+// decodeDefault decodes the default tag of a field absent from the config,
+// errors are located at the struct holding the field.
+// decodeAbsent sets the field absent from the object n to its default,
+// or the fields of a struct without one to theirs.
+func (d *Unmarshaler) decodeAbsent(path string, n *ast.Node, f *field, v reflect.Value) error {
+	if f.hasDef {
+		return d.decodeDefault(path, n, f, v)
+	}
+	s, ok := absentStruct(v, f)
+	if !ok {
+		return nil
+	}
+	path = appendPath(path, f.name)
+	var errs DecodeErrors
+	fields := cachedStructFields(s.Type())
+	for i := range fields.list {
+		err := d.decodeAbsent(path, n, &fields.list[i], s)
+		if err != nil {
+			if !d.AllErrors {
 				return err
 			}
+			errs = appendError(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+// absentStruct returns the struct the field holds directly or through a non-nil pointer,
+// the nil pointers on the way are not allocated and the types with their own decoding are left alone.
+func absentStruct(v reflect.Value, f *field) (reflect.Value, bool) {
+	for i, x := range f.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct || isCustom(v.Type()) {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+func (d *Unmarshaler) decodeDefault(path string, n *ast.Node, f *field, v reflect.Value) error {
+	path = appendPath(path, f.name)
+	field, err := fieldByIndex(v, f.index)
+	if err == nil {
+		if d.Merge && !field.IsZero() {
+			// Values set by the caller take precedence over the default.
+			return nil
 		}
+		field.Set(reflect.Zero(f.typ))
+		err = d.decode(path, defaultNode(f.def, f.typ), field.Addr())
+	}
+	if err != nil {
+		return newDecodeError(path, n, "", f.typ, fmt.Errorf("default %q: %w", f.def, err))
 	}
 	return nil
 }
 
-func (d *Unmarshaler) decodeOther(config []byte, v reflect.Value) error {
-	switch config[0] {
-	case '[':
+// checkUnknownFields reports the members not consumed by any field when DisallowUnknownFields is set,
+// err is the error of decoding the fields and is returned along with them.
+func (d *Unmarshaler) checkUnknownFields(path string, n *ast.Node, used map[string]bool, err error) error {
+	if !d.DisallowUnknownFields {
+		return err
+	}
+	var errs DecodeErrors
+	if err != nil {
+		errs = appendError(errs, err)
+	}
+	for i := range n.Members {
+		m := &n.Members[i]
+		if used[m.Key] || d.Provider.IsDiscriminator(m.Key) || isReservedKey(m.Key) {
+			continue
+		}
+		e := newDecodeError(appendPath(path, m.Key), nil, "", nil, fmt.Errorf("%w %q", ErrUnknownField, m.Key))
+		e.setPosition(m.KeyPos())
+		errs = append(errs, e)
+		if !d.AllErrors {
+			return errs[len(errs)-1]
+		}
+	}
+	return errs.err()
+}
+
+func (d *Unmarshaler) decodeOther(path string, n *ast.Node, v reflect.Value) error {
+	if d.Merge {
+		v = indirectInterface(n, v)
+	}
+	if ok, err := d.decodeCustom(path, n, v); ok {
+		return err
+	}
+	switch n.Kind {
+	case ast.Array:
 		v := indirectElem(v)
 		switch v.Kind() {
 		case reflect.Array:
-			return d.decodeArray(config, v)
+			return d.decodeArray(path, n, v)
 		case reflect.Slice:
-			return d.decodeSlice(config, v)
+			return d.decodeSlice(path, n, v)
 		}
-	case '{':
+	case ast.Object:
 		v := indirectElem(v)
 		switch v.Kind() {
 		case reflect.Map:
-			return d.decodeMap(config, v)
+			return d.decodeMap(path, n, v)
 		case reflect.Struct:
-			return d.decodeStruct(config, v)
+			return d.decodeStruct(path, n, v)
 		}
 	}
+	if n.Interpolated {
+		n = interpolatedScalar(n, v.Type().Elem())
+	}
+	return decodeScalar(n, v)
+}
 
-	err := json.Unmarshal(config, v.Interface())
+func (d *Unmarshaler) decode(path string, n *ast.Node, value reflect.Value) error {
+	kind, err := d.decodeValue(path, n, value)
+	if err == nil && kind == "" {
+		err = callValidate(value)
+	}
 	if err != nil {
-		return err
+		return wrapError(path, n, kind, value, err)
 	}
-
 	return nil
 }
 
-func (d *Unmarshaler) decode(config []byte, value reflect.Value) error {
-	if len(config) == 0 {
-		return ErrFormat
+func (d *Unmarshaler) decodeValue(path string, n *ast.Node, value reflect.Value) (string, error) {
+	if n == nil {
+		return "", ErrFormat
 	}
 	if value.Kind() != reflect.Ptr {
-		return ErrParsedParameter
+		return "", ErrParsedParameter
 	}
 
 	if !value.Elem().CanSet() {
-		return ErrMustBeAssignable
+		return "", ErrMustBeAssignable
 	}
 
-	config = bytes.TrimSpace(config)
-	if config[0] != '{' {
-		return d.decodeOther(config, value)
+	if m, ok := lookupFold(n, refKey); ok {
+		return "", d.decodeRef(path, n, m, value)
 	}
 
-	kind := d.Provider.Kind(config)
+	kind, config := d.Provider.Resolve(n)
+	if kind != "" && config == d.node {
+		// A nested Unmarshal of the component's own config, decode its fields rather than build it again.
+		kind = ""
+	}
+	if kind == "" && n.Kind == ast.String && acceptsShorthand(value.Type().Elem()) {
+		var err error
+		kind, config, err = d.shorthand(n)
+		if err != nil {
+			return kind, err
+		}
+	}
 	if kind == "" {
-		return d.decodeOther(config, value)
+		return "", d.decodeOther(path, n, value)
 	}
 
 	fun, ok := d.Provider.Find(kind)
 	if !ok {
-		return fmt.Errorf("not found %q in provider", kind)
+		return kind, fmt.Errorf("not found %q in provider", kind)
 	}
+	path = configPath(path, n, config)
 
-	err := d.unmarshalKind(fun, kind, config, value)
+	if m, ok := lookupFold(config, nameKey); ok {
+		return kind, d.decodeNamed(path, fun, kind, config, m, value)
+	}
+
+	err := d.unmarshalKind(path, fun, kind, config, value)
 	if err != nil {
-		return err
+		return kind, err
 	}
 
-	return nil
+	return kind, nil
+}
+
+func (d *Unmarshaler) unmarshalKind(path string, fun reflect.Value, kind string, config *ast.Node, value reflect.Value) error {
+	r, err := d.construct(path, fun, kind, config, value)
+	if err != nil {
+		return err
+	}
+	return setResult(value, r)
 }
 
-func (d *Unmarshaler) unmarshalKind(fun reflect.Value, kind string, config []byte, value reflect.Value) error {
+// construct decodes the parameters of the constructor from the config and calls it.
+func (d *Unmarshaler) construct(path string, fun reflect.Value, kind string, config *ast.Node, value reflect.Value) (reflect.Value, error) {
+	// Unmarshal of the config by the constructor decodes it in place.
+	sub := *d
+	sub.node = config
+	sub.path = path
+	sub.config = config.Bytes()
+
 	inj := inject.NewInjector(d.Inject)
-	args := []interface{}{d, &d.Ctx, inj, kind, config, &value}
+	args := []interface{}{&sub, &d.Ctx, inj, kind, sub.config, config, &value}
 	for _, arg := range args {
 		err := inj.Map(reflect.ValueOf(arg))
 		if err != nil {
-			return err
+			return reflect.Value{}, err
 		}
 	}
-	funType := fun.Type()
-	num := funType.NumIn()
-	for i := 0; i != num; i++ {
-		in := funType.In(i)
-		switch in.Kind() {
-		case reflect.Slice:
-			if in.Elem().Kind() == reflect.Uint8 {
-				continue
-			}
-		case reflect.Array, reflect.Struct, reflect.Map:
-		case reflect.Ptr:
-			if in.Elem().Kind() != reflect.Struct {
-				continue
-			}
-		default:
-			continue
+	var errs DecodeErrors
+	used := map[string]bool{}
+	if takesConfig(fun.Type()) && config.Kind == ast.Object {
+		// The constructor consumes the whole config, a nested Unmarshal checks the fields.
+		for _, m := range config.Members {
+			used[m.Key] = true
 		}
-
+	}
+	for _, in := range cachedParams(fun.Type()) {
 		n := reflect.New(in)
-		err := d.decodeOther(config, n)
+		err := d.decodeParam(path, config, n, used)
+		if err == nil {
+			err = callValidate(n)
+		}
 		if err != nil {
-			return err
+			if !d.AllErrors {
+				return reflect.Value{}, err
+			}
+			errs = appendError(errs, wrapError(path, config, kind, n, err))
+			continue
 		}
 		err = inj.Map(n)
 		if err != nil {
-			return err
+			return reflect.Value{}, err
+		}
+	}
+	if d.DisallowUnknownFields {
+		err := d.checkUnknownFields(path, config, used, errs.err())
+		if err != nil {
+			return reflect.Value{}, err
 		}
 	}
+	if len(errs) != 0 {
+		return reflect.Value{}, errs
+	}
 
 	r, err := callWithInject(fun, inj)
 	if err != nil {
-		return err
+		if errs, ok := err.(DecodeErrors); ok {
+			// All the errors of a nested Unmarshal, already located in the tree.
+			return reflect.Value{}, errs
+		}
+		// The constructor may itself return a *DecodeError from a nested
+		// Unmarshal, so always record the location of this component.
+		return reflect.Value{}, newDecodeError(path, config, kind, value.Type().Elem(), err)
 	}
+	return r, nil
+}
 
-	value = indirectElem(value)
-	r, err = indirectTo(r, value.Type())
-	if err != nil {
-		return fmt.Errorf("%q %w", config, err)
+// takesConfig reports whether the constructor takes the raw config or the Unmarshaler to decode it itself.
+func takesConfig(funType reflect.Type) bool {
+	num := funType.NumIn()
+	for i := 0; i != num; i++ {
+		switch in := funType.In(i); {
+		case in.Kind() == reflect.Slice && in.Elem().Kind() == reflect.Uint8,
+			in == astNodeType, in == unmarshalerType:
+			return true
+		}
 	}
+	return false
+}
+
+var (
+	astNodeType     = reflect.TypeOf((*ast.Node)(nil))
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil))
+)
 
-	err = setValue(value, r)
+// setResult sets the value returned by a constructor to the value v points to,
+// a pointer returned is kept rather than copied if it fits, so that it can be shared.
+func setResult(v reflect.Value, r reflect.Value) error {
+	e := r
+	if e.Kind() == reflect.Interface {
+		e = e.Elem()
+	}
+	v = v.Elem()
+	for v.Kind() == reflect.Ptr && !(e.IsValid() && e.Type().AssignableTo(v.Type())) {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	r, err := indirectTo(r, v.Type())
 	if err != nil {
 		return err
 	}
-	return nil
+	return setValue(v, r)
+}
+
+// configPath returns the path of the config of the component at the node,
+// it is the node itself or one of its members.
+func configPath(path string, n *ast.Node, config *ast.Node) string {
+	if config == n {
+		return path
+	}
+	for _, m := range n.Members {
+		if m.Value == config {
+			return appendPath(path, m.Key)
+		}
+	}
+	return path
+}
+
+// decodeParam decodes a constructor parameter from the component config and records the keys consumed in used.
+func (d *Unmarshaler) decodeParam(path string, config *ast.Node, v reflect.Value, used map[string]bool) error {
+	if config.Kind == ast.Object {
+		if !isCustom(v.Type().Elem()) {
+			if e := indirectElem(v); e.Kind() == reflect.Struct {
+				return d.decodeFields(path, config, e, used)
+			}
+		}
+		// Maps and custom decoding consume the whole config.
+		for _, m := range config.Members {
+			used[m.Key] = true
+		}
+	}
+	return d.decodeOther(path, config, v)
+}
+
+// unquoteField returns the value encoded in the JSON string of a field with the ",string" option.
+func unquoteField(n *ast.Node, typ reflect.Type) (*ast.Node, error) {
+	if n.Kind == ast.Null {
+		return n, nil
+	}
+	if n.Kind != ast.String {
+		return nil, fmt.Errorf("invalid use of ,string struct tag, trying to unmarshal unquoted value into %v", typ)
+	}
+	s := n.Value
+	kind := typ.Kind()
+	if kind == reflect.Ptr {
+		kind = typ.Elem().Kind()
+	}
+	item, err := ast.Parse(&ast.Source{Data: []byte(s)})
+	if err == nil {
+		switch {
+		case item.Kind == ast.Null:
+		case kind == reflect.String:
+			if item.Kind != ast.String {
+				err = ErrFormat
+			}
+		case kind == reflect.Bool:
+			if item.Kind != ast.Bool {
+				err = ErrFormat
+			}
+		default:
+			if item.Kind != ast.Number {
+				err = ErrFormat
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid use of ,string struct tag, trying to unmarshal %q into %v", s, typ)
+	}
+	// Locate the errors of the value at the string holding it.
+	relocate(item, n)
+	return item, nil
 }
 
 func setValue(value reflect.Value, r reflect.Value) error {
@@ -318,6 +800,22 @@ func indirectTo(v reflect.Value, to reflect.Type) (r reflect.Value, err error) {
 	return indirectTo(v.Elem(), to)
 }
 
+// indirectInterface returns the pointer held by the interface v points to, so that it is decoded in place.
+func indirectInterface(n *ast.Node, v reflect.Value) reflect.Value {
+	if n.Kind == ast.Null {
+		return v
+	}
+	e := v.Elem()
+	if e.Kind() != reflect.Interface || e.IsNil() {
+		return v
+	}
+	e = e.Elem()
+	if e.Kind() != reflect.Ptr || e.IsNil() {
+		return v
+	}
+	return e
+}
+
 func indirectElem(v reflect.Value) reflect.Value {
 	if v.Kind() != reflect.Ptr {
 		return v
@@ -327,3 +825,8 @@ func indirectElem(v reflect.Value) reflect.Value {
 	}
 	return indirectElem(v.Elem())
 }
+
+// sameBytes reports whether a and b are the same slice of memory.
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && len(a) != 0 && &a[0] == &b[0]
+}
@@ -2,9 +2,19 @@ package unmarshaler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/wzshiming/funcfg/ast"
 	"github.com/wzshiming/funcfg/types"
 	"github.com/wzshiming/inject"
 )
@@ -15,6 +25,8 @@ type Config struct {
 
 func (c Config) M() {}
 
+type ConfigName string
+
 type Adapter interface {
 	M()
 }
@@ -86,6 +98,19 @@ func TestUnmarshaler(t *testing.T) {
 			args: args{ctx, []byte(`{"name":{"@Kind":"hello1"},"name2":{"@Kind":"hello2"}}`)},
 			want: map[string]Config{"name": {"hello1"}, "name2": {"hello2"}},
 		},
+		{
+			args: args{ctx, []byte(`{"1":{"@Kind":"hello1"},"-2":{"@Kind":"hello2"}}`)},
+			want: map[int]Config{1: {"hello1"}, -2: {"hello2"}},
+		},
+		{
+			args: args{ctx, []byte(`{"a":{"@Kind":"hello1"}}`)},
+			want: map[ConfigName]*Config{"a": {"hello1"}},
+		},
+		{
+			args:    args{ctx, []byte(`{"a":{"@Kind":"hello1"}}`)},
+			want:    map[uint]Config{},
+			wantErr: true,
+		},
 	}
 
 	fun := []interface{}{
@@ -231,3 +256,1882 @@ func Test_callWithInject(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeError(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	errBroken := errors.New("broken")
+	err := provider.Register("broken", func() (Adapter, error) {
+		return nil, errBroken
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("ok", func() (Adapter, error) {
+		return Config{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		config   string
+		want     interface{}
+		wantPath string
+		wantKind string
+		wantErr  error
+	}{
+		{
+			config:   `{"Servers":[{"Handler":{"@kind":"ok"}},{},{"Handler":{"@kind":"broken"}}]}`,
+			want:     &struct{ Servers []struct{ Handler Adapter } }{},
+			wantPath: "/Servers/2/Handler",
+			wantKind: "broken",
+			wantErr:  errBroken,
+		},
+		{
+			config:   `{"a/b":{"c~d":{"@kind":"missing"}}}`,
+			want:     &map[string]map[string]Adapter{},
+			wantPath: "/a~1b/c~0d",
+			wantKind: "missing",
+		},
+		{
+			config:   `{"A":[1,"2"]}`,
+			want:     &struct{ A []int }{},
+			wantPath: "/A/1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Unmarshaler{
+				Ctx:      context.Background(),
+				Provider: provider,
+			}
+			err := u.Unmarshal([]byte(tt.config), tt.want)
+			var e *DecodeError
+			if !errors.As(err, &e) {
+				t.Fatalf("Unmarshal() error = %v, want *DecodeError", err)
+			}
+			if e.Path != tt.wantPath {
+				t.Errorf("DecodeError.Path = %q, want %q", e.Path, tt.wantPath)
+			}
+			if e.Kind != tt.wantKind {
+				t.Errorf("DecodeError.Kind = %q, want %q", e.Kind, tt.wantKind)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeErrorPosition(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("broken", func() (Adapter, error) {
+		return nil, errors.New("broken")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   interface{}
+		line   int
+		column int
+	}{
+		{
+			config: "{\n  \"A\": [\n    1,\n    \"2\"\n  ]\n}",
+			want:   &struct{ A []int }{},
+			line:   4,
+			column: 5,
+		},
+		{
+			config: "{\n  \"B\": {\"C\": null,\n    \"D\":   {\"@kind\": \"broken\"}}\n}",
+			want:   &map[string]map[string]Adapter{},
+			line:   3,
+			column: 12,
+		},
+		{
+			config: "{\n  \"A\": [1,,]\n}",
+			want:   &struct{ A []int }{},
+			line:   2,
+			column: 11,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Unmarshaler{
+				Ctx:      context.Background(),
+				Provider: provider,
+				Filename: "config.json",
+			}
+			err := u.Unmarshal([]byte(tt.config), tt.want)
+			var e *DecodeError
+			if !errors.As(err, &e) {
+				t.Fatalf("Unmarshal() error = %v, want *DecodeError", err)
+			}
+			if e.Filename != "config.json" || e.Line != tt.line || e.Column != tt.column {
+				t.Errorf("DecodeError position = %s:%d:%d, want config.json:%d:%d", e.Filename, e.Line, e.Column, tt.line, tt.column)
+			}
+		})
+	}
+}
+
+func TestAllErrors(t *testing.T) {
+	errBroken := errors.New("broken")
+	provider := types.NewEmptyProvider()
+	err := provider.Register("broken", func() (Adapter, error) {
+		return nil, errBroken
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("ok", func(conf struct{ Name string }) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := `{
+		"A": [{"@kind":"ok"}, {"@kind":"missing"}, {"@kind":"broken"}],
+		"B": {"x": {"@kind":"ok","Name":1}, "y": {"@kind":"ok"}},
+		"C": "3"
+	}`
+	var got struct {
+		A []Adapter
+		B map[string]Adapter
+		C int
+	}
+	u := Unmarshaler{
+		Ctx:       context.Background(),
+		Provider:  provider,
+		AllErrors: true,
+	}
+	err = u.Unmarshal([]byte(config), &got)
+	var errs DecodeErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Unmarshal() error = %v, want DecodeErrors", err)
+	}
+	paths := []string{}
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	want := []string{"/A/1", "/A/2", "/B/x/Name", "/C"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("DecodeErrors paths = %q, want %q", paths, want)
+	}
+	var e *DecodeError
+	if !errs.As(&e) || e.Path != "/A/1" {
+		t.Errorf("DecodeErrors.As() = %v, want the error of /A/1", e)
+	}
+	if !errs.Is(errBroken) || errs.Is(ErrReference) {
+		t.Errorf("DecodeErrors.Is() does not match the errors")
+	}
+	if got.A[0] != (Config{}) || got.B["y"] != (Config{}) {
+		t.Errorf("Unmarshal() got = %#v, want valid siblings decoded", got)
+	}
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	type Timeout struct {
+		Timeout int
+	}
+	type Retry struct {
+		Retry int `json:"retries"`
+	}
+	provider := types.NewEmptyProvider()
+	err := provider.Register("client", func(t Timeout, r *Retry) (Adapter, error) {
+		return Config{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("labels", func(labels map[string]interface{}) (Adapter, error) {
+		return Config{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("raw", func(config []byte) (Adapter, error) {
+		return Config{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("via", func(u *Unmarshaler, config []byte) (Adapter, error) {
+		var conf struct{ Port int }
+		err := u.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, err
+		}
+		return Config{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		want    interface{}
+		wantErr []string
+	}{
+		{
+			config: `{"@Kind":"client","timeout":1,"retries":2}`,
+			want:   new(Adapter),
+		},
+		{
+			config: `{"@kind":"labels","anything":1}`,
+			want:   new(Adapter),
+		},
+		{
+			config: `{"@kind":"raw","foo":1}`,
+			want:   new(Adapter),
+		},
+		{
+			config:  `{"@kind":"via","port":1,"bad":2}`,
+			want:    new(Adapter),
+			wantErr: []string{"/bad"},
+		},
+		{
+			config:  `{"@kind":"client","timout":1,"retries":2,"retry":3}`,
+			want:    new(Adapter),
+			wantErr: []string{"/timout", "/retry"},
+		},
+		{
+			config:  `{"A":{"timeout":1,"Timeoutt":1},"B":2}`,
+			want:    &struct{ A Timeout }{},
+			wantErr: []string{"/A/Timeoutt", "/B"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Unmarshaler{
+				Ctx:                   context.Background(),
+				Provider:              provider,
+				AllErrors:             true,
+				DisallowUnknownFields: true,
+			}
+			err := u.Unmarshal([]byte(tt.config), tt.want)
+			var paths []string
+			var errs DecodeErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					if !errors.Is(e, ErrUnknownField) {
+						t.Errorf("DecodeError = %v, want %v", e, ErrUnknownField)
+					}
+					paths = append(paths, e.Path)
+				}
+			} else if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(paths, tt.wantErr) {
+				t.Errorf("Unmarshal() unknown fields = %q, want %q", paths, tt.wantErr)
+			}
+		})
+	}
+}
+
+type CommonOptions struct {
+	Name    string
+	Timeout int
+}
+
+type LabelOptions struct {
+	Labels map[string]string
+}
+
+func TestEmbeddedStruct(t *testing.T) {
+	type Conflict1 struct{ Value int }
+	type Conflict2 struct{ Value int }
+	type Options struct {
+		CommonOptions
+		*LabelOptions
+		Conflict1
+		Conflict2
+		Timeout string
+	}
+	provider := types.NewEmptyProvider()
+	err := provider.Register("embedded", func(opts *Options) (*Options, error) {
+		return opts, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := `{"@kind":"embedded","name":"n","timeout":"1s","labels":{"a":"b"},"value":1}`
+	want := Options{
+		CommonOptions: CommonOptions{Name: "n"},
+		LabelOptions:  &LabelOptions{Labels: map[string]string{"a": "b"}},
+		Timeout:       "1s",
+	}
+
+	u := Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+	}
+	var got Options
+	err = u.Unmarshal([]byte(config), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	type Options struct {
+		Name     string        `default:"anonymous"`
+		Timeout  time.Duration `default:"1m30s"`
+		Interval time.Duration `default:"1000"`
+		Retries  *int          `default:"3"`
+		Hosts    []string      `default:"[\"a\",\"b\"]"`
+		Enabled  bool          `default:"true"`
+		Output   Adapter       `default:"{\"@kind\":\"hello1\"}"`
+	}
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello1", func(name string) (Adapter, error) {
+		return Config{Name: name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("options", func(opts *Options) (*Options, error) {
+		return opts, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retries := 3
+	override := 5
+	tests := []struct {
+		name   string
+		config string
+		want   Options
+	}{
+		{
+			config: `{}`,
+			want: Options{
+				Name:     "anonymous",
+				Timeout:  90 * time.Second,
+				Interval: 1000,
+				Retries:  &retries,
+				Hosts:    []string{"a", "b"},
+				Enabled:  true,
+				Output:   Config{Name: "hello1"},
+			},
+		},
+		{
+			config: `{"@kind":"options","name":"x","retries":5,"hosts":[],"enabled":false,"output":null}`,
+			want: Options{
+				Name:     "x",
+				Timeout:  90 * time.Second,
+				Interval: 1000,
+				Retries:  &override,
+				Hosts:    []string{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Unmarshaler{
+				Ctx:      context.Background(),
+				Provider: provider,
+			}
+			var got Options
+			err := u.Unmarshal([]byte(tt.config), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	// The defaults of a nested struct apply when its key is absent too.
+	type Inner struct {
+		Timeout time.Duration `default:"5s"`
+	}
+	type Outer struct {
+		Inner Inner
+		Ptr   *Inner
+		Set   *Inner
+	}
+	for _, config := range []string{`{}`, `{"Inner":{}}`} {
+		for _, merge := range []bool{false, true} {
+			u := Unmarshaler{
+				Ctx:      context.Background(),
+				Provider: provider,
+				Merge:    merge,
+			}
+			got := Outer{Set: &Inner{}}
+			err := u.Unmarshal([]byte(config), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Outer{Inner: Inner{5 * time.Second}}
+			if merge {
+				// The pointer set by the caller is kept and its target gets the defaults.
+				want.Set = &Inner{5 * time.Second}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Unmarshal(%s) with Merge %v got = %#v, want %#v", config, merge, got, want)
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	type Inner struct {
+		A int
+		B int
+	}
+	type Options struct {
+		Name    string
+		Port    int `default:"80"`
+		Inner   Inner
+		Ptr     *Inner
+		Map     map[string]Inner
+		List    []Inner
+		Array   [2]int
+		Adapter interface{}
+	}
+	ptr := &Inner{A: 1, B: 2}
+	got := Options{
+		Name:    "base",
+		Port:    8080,
+		Inner:   Inner{A: 1, B: 2},
+		Ptr:     ptr,
+		Map:     map[string]Inner{"x": {A: 1, B: 2}, "y": {A: 1}},
+		List:    []Inner{{A: 1, B: 2}, {A: 3}},
+		Array:   [2]int{1, 2},
+		Adapter: &Inner{A: 1},
+	}
+	config := `{
+		"inner": {"b": 3},
+		"ptr": {"a": 4},
+		"map": {"x": {"b": 5}, "z": {"a": 6}},
+		"list": [{"b": 7}],
+		"array": [8],
+		"adapter": {"b": 9}
+	}`
+	want := Options{
+		Name:    "base",
+		Port:    8080,
+		Inner:   Inner{A: 1, B: 3},
+		Ptr:     &Inner{A: 4, B: 2},
+		Map:     map[string]Inner{"x": {A: 1, B: 5}, "y": {A: 1}, "z": {A: 6}},
+		List:    []Inner{{A: 1, B: 7}},
+		Array:   [2]int{8, 2},
+		Adapter: &Inner{A: 1, B: 9},
+	}
+
+	u := Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: types.NewEmptyProvider(),
+		Merge:    true,
+	}
+	err := u.Unmarshal([]byte(config), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+	if got.Ptr != ptr {
+		t.Errorf("Unmarshal() replaced the pointer target")
+	}
+}
+
+type ValidatedOptions struct {
+	Name    string   `validate:"required"`
+	Workers int      `validate:"min=1,max=8"`
+	Mode    string   `validate:"oneof=a b" default:"a"`
+	Tags    []string `validate:"max=2"`
+	Port    *int     `validate:"min=1"`
+}
+
+func (o *ValidatedOptions) Validate() error {
+	if o.Mode == "b" && o.Workers > 1 {
+		return errors.New("mode b is single worker")
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("validated", func(opts ValidatedOptions) (Adapter, error) {
+		return Config{Name: opts.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr []string
+	}{
+		{
+			config: `{"@kind":"validated","name":"x","workers":2}`,
+		},
+		{
+			config:  `{"@kind":"validated","workers":0,"mode":"c","tags":["a","b","c"],"port":0}`,
+			wantErr: []string{"/Name", "/workers", "/mode", "/tags", "/port"},
+		},
+		{
+			config:  `{"a":{"@kind":"validated","name":"x","workers":2,"mode":"b"}}`,
+			wantErr: []string{"/a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Unmarshaler{
+				Ctx:       context.Background(),
+				Provider:  provider,
+				AllErrors: true,
+			}
+			var got map[string]Adapter
+			var err error
+			if strings.HasPrefix(tt.config, `{"@kind"`) {
+				var a Adapter
+				err = u.Unmarshal([]byte(tt.config), &a)
+			} else {
+				err = u.Unmarshal([]byte(tt.config), &got)
+			}
+			var paths []string
+			var errs DecodeErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					paths = append(paths, e.Path)
+				}
+			} else if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(paths, tt.wantErr) {
+				t.Errorf("Unmarshal() error = %v, want paths %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The rules of a nested struct are checked when its key is absent too, not through a nil pointer.
+	type Inner struct {
+		Retries int    `validate:"min=1"`
+		Name    string `validate:"required"`
+	}
+	var got struct {
+		Inner Inner
+		Ptr   *Inner
+	}
+	u := Unmarshaler{
+		Ctx:       context.Background(),
+		Provider:  provider,
+		AllErrors: true,
+	}
+	err = u.Unmarshal([]byte(`{}`), &got)
+	var paths []string
+	var errs DecodeErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+	}
+	if want := []string{"/Inner/Retries", "/Inner/Name"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Unmarshal() error = %v, want paths %q", err, want)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello", func(conf struct{ Name string }) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{"@kind":"hello","name":"a"}
+{"@kind":"hello","name":"b"} {"@kind":"hello",
+ "name":"c"}
+	{"@kind":"hello","name":"d"}
+
+{"@kind":"hello","name":1}
+`
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+		Filename: "stdin",
+	}
+	dec := NewDecoder(u, strings.NewReader(input))
+	var got []Adapter
+	for dec.More() {
+		var a Adapter
+		err := dec.Decode(&a)
+		if err != nil {
+			var e *DecodeError
+			if !errors.As(err, &e) {
+				t.Fatalf("Decode() error = %v, want *DecodeError", err)
+			}
+			if e.Line != 6 || e.Column != 25 {
+				t.Errorf("Decode() error = %v, want at 6:25", err)
+			}
+			break
+		}
+		got = append(got, a)
+	}
+	want := []Adapter{Config{"a"}, Config{"b"}, Config{"c"}, Config{"d"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %#v, want %#v", got, want)
+	}
+	var a Adapter
+	if err := dec.Decode(&a); err != io.EOF {
+		t.Errorf("Decode() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestConcurrentUnmarshal(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello", func(conf struct {
+		Name  string `default:"x"`
+		Count int    `validate:"max=10"`
+	}) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i != cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var got []Adapter
+			err := u.Unmarshal([]byte(fmt.Sprintf(`[{"@kind":"hello","name":"%d","count":1},{"@kind":"hello"}]`, i)), &got)
+			if err == nil && !reflect.DeepEqual(got, []Adapter{Config{strconv.Itoa(i)}, Config{"x"}}) {
+				err = fmt.Errorf("got = %#v", got)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+type UpperText string
+
+func (u *UpperText) UnmarshalText(text []byte) error {
+	*u = UpperText(strings.ToUpper(string(text)))
+	return nil
+}
+
+type Point struct {
+	X, Y int
+}
+
+func (p *Point) UnmarshalJSON(data []byte) error {
+	var xy [2]int
+	err := json.Unmarshal(data, &xy)
+	if err != nil {
+		return err
+	}
+	p.X, p.Y = xy[0], xy[1]
+	return nil
+}
+
+type Pipeline struct {
+	Stages []Adapter
+	Count  int
+}
+
+func (p *Pipeline) UnmarshalFuncfg(u *Unmarshaler, config []byte) error {
+	var aux struct {
+		Stages []Adapter
+	}
+	err := u.Unmarshal(config, &aux)
+	if err != nil {
+		return err
+	}
+	p.Stages = aux.Stages
+	p.Count = len(aux.Stages)
+	return nil
+}
+
+func TestCustomUnmarshaler(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello", func(conf struct {
+		Name UpperText
+		At   Point
+	}) (Adapter, error) {
+		return Config{Name: fmt.Sprintf("%s@%d,%d", conf.Name, conf.At.X, conf.At.Y)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+	}
+
+	type Target struct {
+		Name     UpperText
+		Ptr      **UpperText
+		Point    *Point
+		Pipeline Pipeline
+	}
+	var got Target
+	err = u.Unmarshal([]byte(`{
+  "name": "a",
+  "ptr": "b",
+  "point": [1, 2],
+  "pipeline": {"stages": [{"@kind": "hello", "name": "c", "at": [3, 4]}]}
+}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := UpperText("B")
+	pb := &b
+	want := Target{
+		Name:     "A",
+		Ptr:      &pb,
+		Point:    &Point{1, 2},
+		Pipeline: Pipeline{Stages: []Adapter{Config{"C@3,4"}}, Count: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	err = u.Unmarshal([]byte(`{"pipeline": {"stages": [{"@kind": "hello"},
+  {"@kind": "hello", "at": "x"}]}}`), &got)
+	var e *DecodeError
+	if !errors.As(err, &e) {
+		t.Fatalf("Unmarshal() error = %v, want *DecodeError", err)
+	}
+	if e.Path != "/pipeline/stages/1/at" || e.Line != 2 || e.Column != 28 {
+		t.Errorf("Unmarshal() error = %v, want /pipeline/stages/1/at at 2:28", err)
+	}
+}
+
+func TestDiscriminator(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []types.Option
+		config  string
+		want    Adapter
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			config: `{"@KIND":"hello","name":"a"}`,
+			want:   Config{"a"},
+		},
+		{
+			name:   "custom",
+			opts:   []types.Option{types.WithDiscriminator("type")},
+			config: `{"Type":"hello","name":"a"}`,
+			want:   Config{"a"},
+		},
+		{
+			name:    "custom ignores default",
+			opts:    []types.Option{types.WithDiscriminator("type")},
+			config:  `{"@kind":"hello","name":"a"}`,
+			wantErr: true,
+		},
+		{
+			name:   "exact",
+			opts:   []types.Option{types.WithDiscriminator("$type"), types.WithExactMatch()},
+			config: `{"$type":"hello","name":"a"}`,
+			want:   Config{"a"},
+		},
+		{
+			name:    "exact mismatch",
+			opts:    []types.Option{types.WithDiscriminator("$type"), types.WithExactMatch()},
+			config:  `{"$Type":"hello","name":"a"}`,
+			wantErr: true,
+		},
+		{
+			name:   "wrapper",
+			opts:   []types.Option{types.WithResolvers(types.InlineResolver, types.WrapperResolver)},
+			config: `{"hello":{"name":"a"}}`,
+			want:   Config{"a"},
+		},
+		{
+			name:   "wrapper inline",
+			opts:   []types.Option{types.WithResolvers(types.InlineResolver, types.WrapperResolver)},
+			config: `{"@kind":"hello","name":"a"}`,
+			want:   Config{"a"},
+		},
+		{
+			name:    "wrapper unknown kind",
+			opts:    []types.Option{types.WithResolvers(types.WrapperResolver)},
+			config:  `{"other":{"name":"a"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "wrapper not single key",
+			opts:    []types.Option{types.WithResolvers(types.WrapperResolver)},
+			config:  `{"hello":{"name":"a"},"name":"b"}`,
+			wantErr: true,
+		},
+		{
+			name:    "wrapper disabled",
+			config:  `{"hello":{"name":"a"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := types.NewEmptyProvider(tt.opts...)
+			err := provider.Register("hello", func(conf struct{ Name string }) (Adapter, error) {
+				return Config{Name: conf.Name}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := &Unmarshaler{
+				Ctx:                   context.Background(),
+				Provider:              provider,
+				DisallowUnknownFields: true,
+			}
+			var got Adapter
+			err = u.Unmarshal([]byte(tt.config), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapperErrorPath(t *testing.T) {
+	provider := types.NewEmptyProvider(types.WithResolvers(types.WrapperResolver))
+	err := provider.Register("hello", func(conf struct{ Name string }) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+	}
+	var got []Adapter
+	err = u.Unmarshal([]byte(`[{"hello":{"name":"a"}},{"hello":{"name":1}}]`), &got)
+	var e *DecodeError
+	if !errors.As(err, &e) || e.Path != "/1/hello/name" {
+		t.Errorf("Unmarshal() error = %v, want at /1/hello/name", err)
+	}
+}
+
+func TestShorthand(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("file", func(conf struct{ Path string }) (Adapter, error) {
+		return Config{Name: "file " + conf.Path}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("redis", func(conf struct {
+		Host string
+		DB   int `json:",string"`
+	}) (Adapter, error) {
+		return Config{Name: fmt.Sprintf("redis %s %d", conf.Host, conf.DB)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.RegisterShorthand("file", types.ShorthandField("path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.RegisterShorthand("redis", types.ShorthandURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+	}
+
+	type Target struct {
+		Outputs []Adapter
+		Cache   *Adapter
+		Name    string
+		Raw     interface{}
+	}
+	var got Target
+	err = u.Unmarshal([]byte(`{
+  "outputs": ["file:/var/log/app.log", {"@kind": "file", "path": "b"}],
+  "cache": "redis://host:6379?db=2",
+  "name": "file:/tmp/a",
+  "raw": "file:/tmp/b"
+}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cache Adapter = Config{"redis host:6379 2"}
+	want := Target{
+		Outputs: []Adapter{Config{"file /var/log/app.log"}, Config{"file b"}},
+		Cache:   &cache,
+		Name:    "file:/tmp/a",
+		Raw:     "file:/tmp/b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	err = u.Unmarshal([]byte(`{"outputs": ["file:a",
+  "redis://host?db=x"]}`), &got)
+	var e *DecodeError
+	if !errors.As(err, &e) {
+		t.Fatalf("Unmarshal() error = %v, want *DecodeError", err)
+	}
+	if e.Path != "/outputs/1/db" || e.Line != 2 || e.Column != 3 {
+		t.Errorf("Unmarshal() error = %v, want /outputs/1/db at 2:3", err)
+	}
+
+	err = u.Unmarshal([]byte(`{"outputs": ["ftp://host"]}`), &got)
+	if err == nil {
+		t.Errorf("Unmarshal() error = nil, want unknown shorthand error")
+	}
+}
+
+type Pool struct {
+	Size int
+}
+
+type Handler struct {
+	Name string
+	Pool *Pool
+	Next Adapter
+}
+
+func (Handler) M() {}
+
+func TestNamedRef(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	pools := 0
+	err := provider.Register("pool", func(conf struct{ Size int }) *Pool {
+		pools++
+		return &Pool{Size: conf.Size}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Register("handler", func(conf Handler) Adapter {
+		return conf
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:                   context.Background(),
+		Provider:              provider,
+		DisallowUnknownFields: true,
+	}
+
+	var got []Adapter
+	err = u.Unmarshal([]byte(`[
+  {"@kind": "handler", "name": "a", "pool": {"@ref": "db"}},
+  {"@kind": "handler", "name": "b", "pool": {"@kind": "pool", "@name": "db", "size": 2}, "@name": "b"},
+  {"@kind": "handler", "name": "c", "pool": {"@ref": "db"}, "next": {"@ref": "b"}, "@name": "c"},
+  {"@ref": "b"}
+]`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pools != 1 {
+		t.Errorf("pool constructed %d times, want 1", pools)
+	}
+	if len(got) != 4 {
+		t.Fatalf("Unmarshal() got = %#v", got)
+	}
+	pool := got[1].(Handler).Pool
+	if pool == nil || pool.Size != 2 || got[0].(Handler).Pool != pool || got[2].(Handler).Pool != pool {
+		t.Errorf("Unmarshal() got = %#v, want the pools shared", got)
+	}
+	if got[2].(Handler).Next.(Handler).Name != "b" || got[3].(Handler).Name != "b" {
+		t.Errorf("Unmarshal() got = %#v, want references to b", got)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		path   string
+		err    string
+	}{
+		{
+			name:   "undefined",
+			config: `[{"@ref": "x"}]`,
+			path:   "/0",
+			err:    `"x" is not defined`,
+		},
+		{
+			name:   "duplicate",
+			config: `[{"@kind": "pool", "@name": "x"}, {"@kind": "pool", "@name": "x"}]`,
+			path:   "/1/@name",
+			err:    `"x" is defined more than once`,
+		},
+		{
+			name: "cycle",
+			config: `[{"@kind": "handler", "@name": "a", "next": {"@ref": "b"}},
+  {"@kind": "handler", "@name": "b", "next": {"@ref": "a"}}]`,
+			path: "/1/next",
+			err:  "cycle a -> b -> a",
+		},
+		{
+			name:   "unknown field",
+			config: `[{"@kind": "handler", "@name": "x"}, {"@ref": "x", "size": 1}]`,
+			path:   "/1/size",
+			err:    "unknown field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Adapter
+			err := u.Unmarshal([]byte(tt.config), &got)
+			var e *DecodeError
+			if !errors.As(err, &e) {
+				t.Fatalf("Unmarshal() error = %v, want *DecodeError", err)
+			}
+			if e.Path != tt.path || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Unmarshal() error = %v, want %q at %s", err, tt.err, tt.path)
+			}
+		})
+	}
+}
+
+func TestPointer(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	pools := 0
+	err := provider.Register("pool", func(conf struct{ Size int }) *Pool {
+		pools++
+		return &Pool{Size: conf.Size}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := map[string]string{
+		"conf/common.json": `{"pool": {"$ref": "pools.json#/small"}}`,
+		"conf/pools.json":  `{"small": {"@kind": "pool", "size": 1}, "bad": {"@kind": "pool", "size": "x"}}`,
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+		Filename: "conf/main.json",
+		RefLoader: func(uri string) ([]byte, error) {
+			doc, ok := docs[uri]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", uri)
+			}
+			return []byte(doc), nil
+		},
+	}
+
+	type Target struct {
+		A, B, C, D *Pool
+		List       []int
+	}
+	var got Target
+	err = u.Unmarshal([]byte(`{
+  "defs": {"a/b": {"@kind": "pool", "size": 2}, "list": [1, 2]},
+  "a": {"$ref": "#/defs/a~1b"},
+  "b": {"$ref": "#/defs/a~1b", "size": 3},
+  "c": {"$ref": "common.json#/pool"},
+  "d": {"$ref": "#/c"},
+  "list": {"$ref": "#/defs/list"}
+}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Target{
+		A:    &Pool{2},
+		B:    &Pool{2},
+		C:    &Pool{1},
+		D:    &Pool{1},
+		List: []int{1, 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+	if pools != 4 || got.A == got.B || got.C == got.D {
+		t.Errorf("pool constructed %d times, want 4 distinct", pools)
+	}
+
+	tests := []struct {
+		name     string
+		config   string
+		path     string
+		filename string
+		err      string
+	}{
+		{
+			name:     "not found",
+			config:   `{"a": {"$ref": "#/x"}}`,
+			path:     "/a/$ref",
+			filename: "conf/main.json",
+			err:      `"x" not found`,
+		},
+		{
+			name:     "cycle",
+			config:   `{"a": {"b": {"$ref": "#/a"}}}`,
+			path:     "/a/b/$ref",
+			filename: "conf/main.json",
+			err:      "cycle",
+		},
+		{
+			name:     "other document",
+			config:   `{"a": {"$ref": "other.json"}}`,
+			path:     "/a/$ref",
+			filename: "conf/main.json",
+			err:      "conf/other.json not found",
+		},
+		{
+			name:     "error in other document",
+			config:   `{"a": {"$ref": "pools.json#/bad"}}`,
+			path:     "/a/size",
+			filename: "conf/pools.json",
+			err:      "cannot unmarshal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Target
+			err := u.Unmarshal([]byte(tt.config), &got)
+			var e *DecodeError
+			if !errors.As(err, &e) {
+				t.Fatalf("Unmarshal() error = %v, want *DecodeError", err)
+			}
+			if e.Path != tt.path || e.Filename != tt.filename || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Unmarshal() error = %v, want %q at %s in %s", err, tt.err, tt.path, tt.filename)
+			}
+		})
+	}
+
+	laughs := `{"l0": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]`
+	for i := 1; i <= 7; i++ {
+		ref := fmt.Sprintf(`{"$ref": "#/l%d"}`, i-1)
+		laughs += fmt.Sprintf(`, "l%d": [%s]`, i, strings.TrimSuffix(strings.Repeat(ref+", ", 10), ", "))
+	}
+	var v interface{}
+	err = u.Unmarshal([]byte(laughs+"}"), &v)
+	if !errors.Is(err, ErrReference) || !strings.Contains(err.Error(), "expansion budget") {
+		t.Errorf("Unmarshal() error = %v, want the expansion budget exceeded", err)
+	}
+
+	strict := *u
+	strict.DisallowUnknownFields = true
+	err = strict.Unmarshal([]byte(`{"defs": {"@kind": "pool", "size": 2}, "a": {"$ref": "#/defs", "size": 3}}`), &got)
+	var e *DecodeError
+	if !errors.As(err, &e) || !errors.Is(err, ErrReference) || e.Path != "/a/size" {
+		t.Errorf("Unmarshal() error = %v, want %v at /a/size", err, ErrReference)
+	}
+}
+
+func Test_expand(t *testing.T) {
+	lookup := MapLookup(map[string]string{
+		"HOST":  "example.com",
+		"PORT":  "8080",
+		"EMPTY": "",
+	})
+	tests := []struct {
+		s       string
+		want    string
+		single  bool
+		wantErr bool
+	}{
+		{s: "plain", want: "plain"},
+		{s: "${HOST}", want: "example.com", single: true},
+		{s: "http://${HOST}:${PORT}/", want: "http://example.com:8080/"},
+		{s: "${MISSING}", want: "", single: true},
+		{s: "${MISSING:-80}", want: "80", single: true},
+		{s: "${EMPTY:-80}", want: "80", single: true},
+		{s: "${EMPTY-80}", want: "", single: true},
+		{s: "${PORT:-80}", want: "8080", single: true},
+		{s: "${HOST:?required}", want: "example.com", single: true},
+		{s: "${MISSING:?required}", wantErr: true},
+		{s: "${EMPTY:?}", wantErr: true},
+		{s: "${EMPTY?}", want: "", single: true},
+		{s: "$$HOST $HOST $", want: "$HOST $HOST $"},
+		{s: "${HOST", wantErr: true},
+		{s: "${1A}", wantErr: true},
+		{s: "${HOST:+x}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, single, err := expand(tt.s, lookup)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInterpolation) {
+					t.Errorf("expand() error = %v, want %v", err, ErrInterpolation)
+				}
+				return
+			}
+			if got != tt.want || single != tt.single {
+				t.Errorf("expand() got = %q, %v, want %q, %v", got, single, tt.want, tt.single)
+			}
+		})
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("server", func(conf struct {
+		Addr string
+		Port int
+	}) (Adapter, error) {
+		return Config{Name: fmt.Sprintf("%s:%d", conf.Addr, conf.Port)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:         context.Background(),
+		Provider:    provider,
+		Interpolate: true,
+		Lookup: MapLookup(map[string]string{
+			"KIND":  "server",
+			"HOST":  "example.com",
+			"PORT":  "8080",
+			"DEBUG": "true",
+		}),
+	}
+
+	type Target struct {
+		Server  Adapter
+		Debug   *bool
+		Timeout float64
+		Name    string
+		Raw     interface{}
+	}
+	var got Target
+	err = u.Unmarshal([]byte(`{
+  "server": {"@kind": "${KIND}", "addr": "${HOST}", "port": "${PORT}"},
+  "debug": "${DEBUG}",
+  "timeout": "${TIMEOUT:-1.5}",
+  "name": "${PORT}",
+  "raw": "${PORT}"
+}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debug := true
+	want := Target{
+		Server:  Config{"example.com:8080"},
+		Debug:   &debug,
+		Timeout: 1.5,
+		Name:    "8080",
+		Raw:     "8080",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	err = u.Unmarshal([]byte(`{"server": {"@kind": "server",
+  "addr": "${ADDR:?is required}", "port": "${HOST}"}}`), &got)
+	var e *DecodeError
+	if !errors.As(err, &e) || !errors.Is(err, ErrInterpolation) {
+		t.Fatalf("Unmarshal() error = %v, want %v", err, ErrInterpolation)
+	}
+	if e.Path != "/server/addr" || e.Line != 2 || e.Column != 11 || !strings.Contains(err.Error(), "ADDR: is required") {
+		t.Errorf("Unmarshal() error = %v, want ADDR: is required at /server/addr 2:11", err)
+	}
+
+	u.Interpolate = false
+	err = u.Unmarshal([]byte(`{"name": "${PORT}"}`), &got)
+	if err != nil || got.Name != "${PORT}" {
+		t.Errorf("Unmarshal() got = %q, %v, want it kept without Interpolate", got.Name, err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello", func(conf struct{ Name string }) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+		Filename: "conf/main.json",
+		FS: fstest.MapFS{
+			"conf/teams/a.json":      {Data: []byte(`{"@kind": "hello", "name": "a"}`)},
+			"conf/teams/b.json":      {Data: []byte(`{"@include": "../common/team.json"}`)},
+			"conf/common/team.json":  {Data: []byte(`{"@kind": "hello", "name": "b"}`)},
+			"conf/common/base.json":  {Data: []byte(`{"name": "base", "alias": {"$ref": "#/name"}}`)},
+			"conf/cycle/a.json":      {Data: []byte(`{"next": {"@include": "b.json"}}`)},
+			"conf/cycle/b.json":      {Data: []byte(`[{"@include": "/conf/cycle/a.json"}]`)},
+			"conf/bad/invalid.json":  {Data: []byte(`{"@kind": "hello",` + "\n" + ` "name": 1}`)},
+			"conf/bad/syntax.json":   {Data: []byte(`{"name": }`)},
+			"conf/empty/.gitkeep":    {},
+			"conf/teams/readme.text": {},
+		},
+	}
+
+	type Target struct {
+		Teams  []Adapter
+		Team   Adapter
+		Common struct{ Name, Alias string }
+		Empty  []Adapter
+	}
+	var got Target
+	err = u.Unmarshal([]byte(`{
+  "teams": {"@include": "teams/*.json"},
+  "team": {"@include": "common/team.json"},
+  "common": {"@include": "common/base.json"},
+  "empty": {"@include": "empty/*.json"}
+}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Target{
+		Teams:  []Adapter{Config{"a"}, Config{"b"}},
+		Team:   Config{"b"},
+		Common: struct{ Name, Alias string }{"base", "base"},
+		Empty:  []Adapter{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		config   string
+		path     string
+		filename string
+		line     int
+		err      string
+	}{
+		{
+			name:     "cycle",
+			config:   `{"team": {"@include": "cycle/a.json"}}`,
+			path:     "/team/next/0/@include",
+			filename: "conf/cycle/b.json",
+			line:     1,
+			err:      "cycle conf/cycle/a.json -> conf/cycle/b.json -> conf/cycle/a.json",
+		},
+		{
+			name:     "not found",
+			config:   `{"team": {"@include": "missing.json"}}`,
+			path:     "/team/@include",
+			filename: "conf/main.json",
+			line:     1,
+			err:      "file does not exist",
+		},
+		{
+			name:     "decode error",
+			config:   `{"team": {"@include": "bad/invalid.json"}}`,
+			path:     "/team/name",
+			filename: "conf/bad/invalid.json",
+			line:     2,
+			err:      "cannot unmarshal",
+		},
+		{
+			name:     "syntax error",
+			config:   `{"team": {"@include": "bad/syntax.json"}}`,
+			filename: "conf/bad/syntax.json",
+			line:     1,
+			err:      "invalid character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Target
+			err := u.Unmarshal([]byte(tt.config), &got)
+			var e *DecodeError
+			if !errors.As(err, &e) {
+				t.Fatalf("Unmarshal() error = %v, want *DecodeError", err)
+			}
+			if e.Path != tt.path || e.Filename != tt.filename || e.Line != tt.line || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Unmarshal() error = %v, want %q at %s in %s:%d", err, tt.err, tt.path, tt.filename, tt.line)
+			}
+		})
+	}
+}
+
+func TestMergeDocuments(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	for _, kind := range []string{"http", "grpc"} {
+		err := provider.Register(kind, func(conf struct{ Port int }) (Adapter, error) {
+			return Config{}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		opts MergeOptions
+		docs []string
+		want string
+	}{
+		// The examples of RFC 7396 Appendix A.
+		{docs: []string{`{"a":"b"}`, `{"a":"c"}`}, want: `{"a":"c"}`},
+		{docs: []string{`{"a":"b"}`, `{"b":"c"}`}, want: `{"a":"b","b":"c"}`},
+		{docs: []string{`{"a":"b"}`, `{"a":null}`}, want: `{}`},
+		{docs: []string{`{"a":"b","b":"c"}`, `{"a":null}`}, want: `{"b":"c"}`},
+		{docs: []string{`{"a":["b"]}`, `{"a":"c"}`}, want: `{"a":"c"}`},
+		{docs: []string{`{"a":"c"}`, `{"a":["b"]}`}, want: `{"a":["b"]}`},
+		{docs: []string{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`}, want: `{"a":{"b":"d"}}`},
+		{docs: []string{`{"a":[{"b":"c"}]}`, `{"a":[1]}`}, want: `{"a":[1]}`},
+		{docs: []string{`["a","b"]`, `["c","d"]`}, want: `["c","d"]`},
+		{docs: []string{`{"a":"b"}`, `["c"]`}, want: `["c"]`},
+		{docs: []string{`{"a":"foo"}`, `null`}, want: `null`},
+		{docs: []string{`{"a":"foo"}`, `"bar"`}, want: `"bar"`},
+		{docs: []string{`{"e":null}`, `{"a":1}`}, want: `{"e":null,"a":1}`},
+		{docs: []string{`[1,2]`, `{"a":"b","c":null}`}, want: `{"a":"b"}`},
+		{docs: []string{`{}`, `{"a":{"bb":{"ccc":null}}}`}, want: `{"a":{"bb":{}}}`},
+		{
+			name: "three layers",
+			docs: []string{`{"a":1,"b":{"c":1}}`, `{"b":{"d":2}}`, `{"a":null,"b":{"c":3}}`},
+			want: `{"b":{"c":3,"d":2}}`,
+		},
+		{
+			name: "same kind",
+			opts: MergeOptions{Provider: provider},
+			docs: []string{`{"@kind":"http","port":80,"host":"a"}`, `{"@kind":"http","port":8080}`},
+			want: `{"@kind":"http","port":8080,"host":"a"}`,
+		},
+		{
+			name: "fields only",
+			opts: MergeOptions{Provider: provider},
+			docs: []string{`{"@kind":"http","port":80,"host":"a"}`, `{"port":8080}`},
+			want: `{"@kind":"http","port":8080,"host":"a"}`,
+		},
+		{
+			name: "other kind",
+			opts: MergeOptions{Provider: provider},
+			docs: []string{`{"s":{"@kind":"http","port":80,"host":"a"}}`, `{"s":{"@kind":"grpc","port":9090,"x":null}}`},
+			want: `{"s":{"@kind":"grpc","port":9090}}`,
+		},
+		{
+			name: "append",
+			opts: MergeOptions{Arrays: ArrayAppend},
+			docs: []string{`{"a":[1],"b":{"c":[2]}}`, `{"a":[3],"b":{"c":[4]}}`},
+			want: `{"a":[1,3],"b":{"c":[2,4]}}`,
+		},
+		{
+			name: "merge by key",
+			opts: MergeOptions{Arrays: ArrayMergeByKey},
+			docs: []string{
+				`{"servers":[{"name":"a","port":1,"tags":["x"]},{"name":"b","port":2},3]}`,
+				`{"servers":[{"name":"b","port":20,"debug":true},{"name":"c"},4]}`,
+			},
+			want: `{"servers":[{"name":"a","port":1,"tags":["x"]},{"name":"b","port":20,"debug":true},3,{"name":"c"},4]}`,
+		},
+		{
+			name: "paths",
+			opts: MergeOptions{
+				Key: "id",
+				Paths: map[string]ArrayStrategy{
+					"/servers":         ArrayMergeByKey,
+					"/servers/*/tags":  ArrayAppend,
+					"/servers/0/ports": ArrayAppend,
+				},
+			},
+			docs: []string{
+				`{"servers":[{"id":1,"tags":["x"],"ports":[1]}],"other":[1]}`,
+				`{"servers":[{"id":1,"tags":["y"],"ports":[2]}],"other":[2]}`,
+			},
+			want: `{"servers":[{"id":1,"tags":["x","y"],"ports":[1,2]}],"other":[2]}`,
+		},
+	}
+	for _, tt := range tests {
+		name := tt.name
+		if name == "" {
+			name = strings.Join(tt.docs, " + ")
+		}
+		t.Run(name, func(t *testing.T) {
+			docs := make([][]byte, 0, len(tt.docs))
+			for _, doc := range tt.docs {
+				docs = append(docs, []byte(doc))
+			}
+			got, err := Merge(tt.opts, docs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Merge() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMerge(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello", func(conf struct{ Name string }) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+	}
+	var got map[string]Adapter
+	err = u.UnmarshalMerge(MergeOptions{}, &got,
+		&ast.Source{Name: "base.json", Data: []byte(`{"a":{"@kind":"hello","name":"a"},"b":{"@kind":"hello","name":"b"}}`)},
+		&ast.Source{Name: "prod.json", Data: []byte(`{"b":{"name":"prod"},"c":{"@kind":"hello"}}`)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Adapter{"a": Config{"a"}, "b": Config{"prod"}, "c": Config{""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalMerge() got = %#v, want %#v", got, want)
+	}
+
+	err = u.UnmarshalMerge(MergeOptions{}, &got,
+		&ast.Source{Name: "base.json", Data: []byte(`{"a":{"@kind":"hello","name":"a"}}`)},
+		&ast.Source{Name: "prod.json", Data: []byte(`{"a":{"name":1}}`)},
+	)
+	var e *DecodeError
+	if !errors.As(err, &e) || e.Path != "/a/name" || e.Filename != "prod.json" {
+		t.Errorf("UnmarshalMerge() error = %v, want at /a/name in prod.json", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	type helloConfig struct {
+		Name string `json:"name,omitempty"`
+	}
+	errNoConfig := fmt.Errorf("no config")
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello", func(conf helloConfig) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.RegisterInverse("hello", func(c Config) (helloConfig, error) {
+		if c.Name == "fail" {
+			return helloConfig{}, errNoConfig
+		}
+		return helloConfig{Name: c.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Its own inverse.
+	err = provider.Register("handler", func(conf Handler) Handler {
+		return conf
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Conf struct {
+		Main   Adapter
+		List   []Adapter
+		ByName map[string]Adapter
+		Skip   Adapter `json:",omitempty"`
+	}
+	m := &Marshaler{Provider: provider}
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "component",
+			value: Config{Name: "a"},
+			want:  `{"@kind":"hello","name":"a"}`,
+		},
+		{
+			name:  "empty config",
+			value: Config{},
+			want:  `{"@kind":"hello"}`,
+		},
+		{
+			name: "fields",
+			value: &Conf{
+				Main: Handler{Name: "h", Next: Config{Name: "next"}},
+				List: []Adapter{Config{Name: "a"}, nil},
+				ByName: map[string]Adapter{
+					"b": &Handler{Pool: &Pool{Size: 2}},
+					"a": Config{Name: "a"},
+				},
+			},
+			want: `{"Main":{"@kind":"handler","Name":"h","Pool":null,"Next":{"@kind":"hello","name":"next"}},` +
+				`"List":[{"@kind":"hello","name":"a"},null],` +
+				`"ByName":{"a":{"@kind":"hello","name":"a"},"b":{"@kind":"handler","Name":"","Pool":{"Size":2},"Next":null}}}`,
+		},
+		{
+			name:  "not a component",
+			value: map[string]interface{}{"a": []int{1}, "b": Pool{Size: 1}},
+			want:  `{"a":[1],"b":{"Size":1}}`,
+		},
+		{
+			name:    "no kind",
+			value:   struct{ Err error }{errors.New("a")},
+			wantErr: ErrMarshal,
+		},
+		{
+			name:    "inverse error",
+			value:   Conf{List: []Adapter{Config{Name: "fail"}}},
+			wantErr: errNoConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Marshal(tt.value)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Marshal() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The config decodes back into the same value.
+	want := Conf{
+		Main:   Handler{Name: "h", Pool: &Pool{Size: 1}, Next: Config{Name: "next"}},
+		List:   []Adapter{Config{Name: "a"}},
+		ByName: map[string]Adapter{"a": Config{}},
+	}
+	data, err := m.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+	}
+	var got Conf
+	err = u.Unmarshal(data, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal(Marshal()) got = %#v, want %#v", got, want)
+	}
+
+	// The style of the kind follows the resolvers of the provider.
+	styles := []struct {
+		resolver types.Resolver
+		want     string
+	}{
+		{resolver: types.InlineResolver, want: `{"Main":{"@kind":"hello","name":"a"}}`},
+		{resolver: types.WrapperResolver, want: `{"Main":{"hello":{"name":"a"}}}`},
+	}
+	for _, style := range styles {
+		provider := types.NewEmptyProvider(types.WithResolvers(style.resolver))
+		err := provider.Register("hello", func(conf helloConfig) (Adapter, error) {
+			return Config{Name: conf.Name}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = provider.RegisterInverse("hello", func(c Config) (helloConfig, error) {
+			return helloConfig{Name: c.Name}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := struct{ Main Adapter }{Config{Name: "a"}}
+		m := &Marshaler{Provider: provider}
+		data, err := m.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != style.want {
+			t.Errorf("Marshal() got = %s, want %s", data, style.want)
+		}
+		u := &Unmarshaler{
+			Ctx:      context.Background(),
+			Provider: provider,
+		}
+		var got struct{ Main Adapter }
+		err = u.Unmarshal(data, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal(Marshal()) got = %#v, want %#v", got, want)
+		}
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	provider := types.NewEmptyProvider()
+	err := provider.Register("hello", func(conf struct{ Name string }) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+		Filename: "conf/main.yaml",
+		FS: fstest.MapFS{
+			"conf/team.yml": {Data: []byte("# a team\n\"@kind\": hello\nname: included\n")},
+		},
+	}
+
+	type Target struct {
+		Main   Adapter
+		Others []Adapter
+		Team   Adapter
+		Port   int
+	}
+	var got Target
+	err = u.UnmarshalYAML([]byte(`
+defaults: &defaults
+  "@kind": hello
+  name: default
+main:
+  <<: *defaults
+  name: main
+others:
+  - *defaults
+  - {"@kind": hello, name: flow}
+team:
+  "@include": team.yml
+port: 8080
+`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Target{
+		Main:   Config{"main"},
+		Others: []Adapter{Config{"default"}, Config{"flow"}},
+		Team:   Config{"included"},
+		Port:   8080,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalYAML() got = %#v, want %#v", got, want)
+	}
+
+	err = u.UnmarshalYAML([]byte("main:\n  \"@kind\": hello\n  name:\n    - x\n"), &got)
+	var e *DecodeError
+	if !errors.As(err, &e) || e.Path != "/main/name" || e.Filename != "conf/main.yaml" || e.Line != 4 || e.Column != 5 {
+		t.Errorf("UnmarshalYAML() error = %v, want at conf/main.yaml:4:5 /main/name", err)
+	}
+	var v interface{} = 1
+	err = u.UnmarshalYAML([]byte(""), &v)
+	if err != nil || v != nil {
+		t.Errorf("UnmarshalYAML() of an empty document got = %v, %v, want nil", v, err)
+	}
+	err = u.UnmarshalYAML([]byte("main: [1,\n  2"), &got)
+	if !errors.As(err, &e) || e.Line != 2 {
+		t.Errorf("UnmarshalYAML() error = %v, want a syntax error on line 2", err)
+	}
+
+	dec := NewYAMLDecoder(u, strings.NewReader("\"@kind\": hello\nname: a\n---\n\"@kind\": hello\nname: b\n"))
+	var names []string
+	for {
+		var a Adapter
+		err := dec.Decode(&a)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, a.(Config).Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("YAMLDecoder.Decode() got = %v", names)
+	}
+}
+
+func TestUnmarshalTOML(t *testing.T) {
+	provider := types.NewEmptyProvider(types.WithDiscriminator("kind"))
+	err := provider.Register("hello", func(conf struct{ Name string }) (Adapter, error) {
+		return Config{Name: conf.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unmarshaler{
+		Ctx:      context.Background(),
+		Provider: provider,
+		Filename: "conf/main.toml",
+		FS: fstest.MapFS{
+			"conf/team.toml": {Data: []byte("kind = \"hello\"\nname = \"included\"\n")},
+		},
+	}
+
+	type Target struct {
+		Main   Adapter
+		Others []Adapter
+		Inline Adapter
+		Team   Adapter
+		Port   int
+	}
+	var got Target
+	err = u.UnmarshalTOML([]byte(`
+port = 8080
+inline = { kind = "hello", name = "inline" }
+team = { "@include" = "team.toml" }
+
+[main]
+kind = "hello"
+name = "main"
+
+[[others]]
+kind = "hello"
+name = "a"
+
+[[others]]
+kind = "hello"
+name = "b"
+`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Target{
+		Main:   Config{"main"},
+		Others: []Adapter{Config{"a"}, Config{"b"}},
+		Inline: Config{"inline"},
+		Team:   Config{"included"},
+		Port:   8080,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalTOML() got = %#v, want %#v", got, want)
+	}
+
+	err = u.UnmarshalTOML([]byte("[main]\nkind = \"hello\"\n\n[[others]]\nkind = \"hello\"\nname = [1]\n"), &got)
+	var e *DecodeError
+	if !errors.As(err, &e) || e.Path != "/others/0/name" || e.Filename != "conf/main.toml" || e.Line != 6 || e.Column != 8 {
+		t.Errorf("UnmarshalTOML() error = %v, want at conf/main.toml:6:8 /others/0/name", err)
+	}
+	err = u.UnmarshalTOML([]byte("port = 1\nport = 2\n"), &got)
+	if !errors.As(err, &e) || e.Line != 2 || e.Column != 1 {
+		t.Errorf("UnmarshalTOML() error = %v, want a syntax error at 2:1", err)
+	}
+}
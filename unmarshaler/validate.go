@@ -0,0 +1,155 @@
+package unmarshaler
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by values that check themselves after they are decoded.
+type Validator interface {
+	Validate() error
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// callValidate calls Validate of the value v points to if it implements Validator.
+func callValidate(v reflect.Value) error {
+	if v.Type().Implements(validatorType) {
+		if v.IsNil() {
+			return nil
+		}
+		return v.Interface().(Validator).Validate()
+	}
+	e := v.Elem()
+	if e.Type().Implements(validatorType) {
+		if e.Kind() == reflect.Ptr || e.Kind() == reflect.Interface {
+			if e.IsNil() {
+				return nil
+			}
+		}
+		return e.Interface().(Validator).Validate()
+	}
+	return nil
+}
+
+// rule is a rule of the validate tag, such as `validate:"required,min=1,oneof=a b"`.
+type rule struct {
+	name  string
+	param string
+}
+
+func parseRules(tag string) []rule {
+	if tag == "" {
+		return nil
+	}
+	rules := []rule{}
+	for _, r := range strings.Split(tag, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		i := strings.Index(r, "=")
+		if i == -1 {
+			rules = append(rules, rule{name: r})
+		} else {
+			rules = append(rules, rule{name: r[:i], param: r[i+1:]})
+		}
+	}
+	return rules
+}
+
+func (r rule) String() string {
+	if r.param == "" {
+		return r.name
+	}
+	return r.name + "=" + r.param
+}
+
+// validateRules checks the value against the rules in order and returns the first failure.
+func validateRules(rules []rule, v reflect.Value) error {
+	for _, r := range rules {
+		if r.name == "required" {
+			if v.IsZero() {
+				return fmt.Errorf("%w: %s: value is required", ErrValidation, r)
+			}
+			continue
+		}
+		e := v
+		for e.Kind() == reflect.Ptr || e.Kind() == reflect.Interface {
+			if e.IsNil() {
+				break
+			}
+			e = e.Elem()
+		}
+		if (e.Kind() == reflect.Ptr || e.Kind() == reflect.Interface) && e.IsNil() {
+			continue
+		}
+		err := validateRule(r, e)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrValidation, r, err)
+		}
+	}
+	return nil
+}
+
+func validateRule(r rule, v reflect.Value) error {
+	switch r.name {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(r.param, 64)
+		if err != nil {
+			return fmt.Errorf("invalid parameter %q", r.param)
+		}
+		n, what, ok := measure(v)
+		if !ok {
+			return fmt.Errorf("not applicable to %s", v.Type())
+		}
+		switch {
+		case r.name == "min" && n < limit:
+			return fmt.Errorf("%s %v is less than %v", what, n, r.param)
+		case r.name == "max" && n > limit:
+			return fmt.Errorf("%s %v is greater than %v", what, n, r.param)
+		case r.name == "len" && n != limit:
+			return fmt.Errorf("%s %v is not %v", what, n, r.param)
+		}
+	case "oneof":
+		var s string
+		switch v.Kind() {
+		case reflect.String, reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64:
+			s = fmt.Sprint(v.Interface())
+		default:
+			return fmt.Errorf("not applicable to %s", v.Type())
+		}
+		for _, o := range strings.Fields(r.param) {
+			if s == o {
+				return nil
+			}
+		}
+		return fmt.Errorf("value %q is not one of %q", s, strings.Fields(r.param))
+	default:
+		return fmt.Errorf("unknown rule")
+	}
+	return nil
+}
+
+// measure returns the number compared by min, max and len: the value of numbers and the length of the others.
+func measure(v reflect.Value) (float64, string, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "value", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), "value", true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "value", true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "length", true
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.Chan:
+		return float64(v.Len()), "length", true
+	}
+	return 0, "", false
+}